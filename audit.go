@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxAuditEntries bounds the audit log. Once full, each new entry
+	// replaces the oldest one.
+	maxAuditEntries = 1000
+	// defaultAuditLimit is the number of entries returned by getAudit
+	// when no limit is given.
+	defaultAuditLimit = 100
+)
+
+// auditEntry records one change made to the catalog or its loans.
+type auditEntry struct {
+	ID int64     `json:"id"`
+	At time.Time `json:"at"`
+	// Op names the change, such as "create", "update" or "checkout".
+	Op     string `json:"op"`
+	BookID int64  `json:"book_id,omitempty"`
+	// Reason explains the change when the client gave one, such as why a
+	// copy was written off.
+	Reason string `json:"reason,omitempty"`
+	// Actor is "admin" for requests authenticated with the admin key and
+	// empty otherwise, since other requests are anonymous.
+	Actor    string `json:"actor,omitempty"`
+	ClientIP string `json:"client_ip"`
+}
+
+// auditLog is a ring buffer of the most recent audit entries. Like the
+// loans, it is guarded by booksMu so a change and its entry are recorded
+// together. It is kept in memory only.
+type auditLog struct {
+	entries [maxAuditEntries]auditEntry
+	next    int   // index the next entry is written at
+	count   int   // number of entries held, up to maxAuditEntries
+	lastID  int64 // ID of the most recent entry
+}
+
+// audit is the audit log of the service.
+var audit = &auditLog{}
+
+// recordAudit adds an entry for the change op made to the book with the
+// given ID, or to no book in particular when bookID is 0, by the request
+// c. The caller must hold the write lock.
+func recordAudit(c *gin.Context, op string, bookID int64) {
+	recordAuditReason(c, op, bookID, "")
+}
+
+// recordAuditReason is like recordAudit, with the reason given for the
+// change. The caller must hold the write lock.
+func recordAuditReason(c *gin.Context, op string, bookID int64, reason string) {
+	e := auditEntry{At: time.Now().UTC(), Op: op, BookID: bookID, Reason: reason, ClientIP: c.ClientIP()}
+	if hasAdminKey(c, cfg.AdminKey) {
+		e.Actor = "admin"
+	}
+	audit.lastID++
+	e.ID = audit.lastID
+	audit.entries[audit.next] = e
+	audit.next = (audit.next + 1) % maxAuditEntries
+	audit.count = min(audit.count+1, maxAuditEntries)
+}
+
+// newestFirst returns the entries held, the most recent first. The caller
+// must hold booksMu.
+func (a *auditLog) newestFirst() []auditEntry {
+	out := make([]auditEntry, a.count)
+	for i := range out {
+		out[i] = a.entries[(a.next-1-i+maxAuditEntries)%maxAuditEntries]
+	}
+	return out
+}
+
+// getAudit handles the admin request for the audit log, most recent change
+// first. It accepts `offset` and `limit` query parameters, 100 entries by
+// default, and sets X-Total-Count to the number of entries held. Only the
+// last 1000 changes are kept.
+func getAudit(c *gin.Context) {
+	offset, limit := 0, defaultAuditLimit
+	if s, ok := c.GetQuery("offset"); ok {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, invalidRequest("Invalid offset").body())
+			return
+		}
+		offset = n
+	}
+	if s, ok := c.GetQuery("limit"); ok {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, invalidRequest("Invalid limit").body())
+			return
+		}
+		limit = min(n, maxAuditEntries)
+	}
+
+	booksMu.RLock()
+	entries := audit.newestFirst()
+	booksMu.RUnlock()
+
+	c.Header("X-Total-Count", strconv.Itoa(len(entries)))
+	entries = entries[min(offset, len(entries)):]
+	entries = entries[:min(limit, len(entries))]
+	renderJSON(c, http.StatusOK, entries)
+}
+
+// auditCSVHeader lists the columns of the audit log CSV export, in order.
+var auditCSVHeader = []string{"timestamp", "actor", "operation", "book_id", "details", "client_ip"}
+
+// exportAuditCSV handles the admin request to export the audit log as CSV
+// for compliance reports, oldest change first. Like exportCSV, rows are
+// written straight to the response with one header row first. Requests
+// authenticated with the admin key have the actor "admin", others none.
+//
+// The optional `from` and `to` query parameters bound the export to the
+// changes made in that period. Each is an RFC 3339 time or a YYYY-MM-DD
+// date, taken as midnight UTC; a `to` date includes that whole day. It
+// responds with 400 Bad Request for an invalid bound. Only the last 1000
+// changes are kept.
+func exportAuditCSV(c *gin.Context) {
+	from, err := parseAuditBound(c.Query("from"), false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, invalidRequest("Invalid from, expected RFC 3339 or YYYY-MM-DD").body())
+		return
+	}
+	to, err := parseAuditBound(c.Query("to"), true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, invalidRequest("Invalid to, expected RFC 3339 or YYYY-MM-DD").body())
+		return
+	}
+
+	booksMu.RLock()
+	entries := audit.newestFirst()
+	booksMu.RUnlock()
+	slices.Reverse(entries)
+
+	name := "audit"
+	if s := c.Query("from"); s != "" {
+		name += "-from-" + slugify(s)
+	}
+	if s := c.Query("to"); s != "" {
+		name += "-to-" + slugify(s)
+	}
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="`+name+`.csv"`)
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write(auditCSVHeader)
+	for _, e := range entries {
+		if e.At.Before(from) || !to.IsZero() && !e.At.Before(to) {
+			continue
+		}
+		bookID := ""
+		if e.BookID != 0 {
+			bookID = strconv.FormatInt(e.BookID, 10)
+		}
+		w.Write([]string{e.At.Format(time.RFC3339Nano), e.Actor, e.Op, bookID, e.Reason, e.ClientIP})
+	}
+	w.Flush()
+}
+
+// parseAuditBound parses a from or to bound of exportAuditCSV, returning
+// the zero time when s is empty. The bound returned for to is exclusive,
+// so a date given as to is moved to the start of the next day.
+func parseAuditBound(s string, to bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		if to {
+			t = t.Add(time.Nanosecond)
+		}
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if to {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
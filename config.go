@@ -0,0 +1,309 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// config holds the runtime settings of the service. Every field is read
+// from an environment variable at startup so the same binary can be
+// configured differently per deployment without code changes.
+type config struct {
+	// CORSAllowedOrigins lists the origins allowed to make cross-origin
+	// requests. A single "*" allows any origin.
+	CORSAllowedOrigins []string
+	// CORSMaxAge is how long browsers may cache a preflight response.
+	// Zero disables caching.
+	CORSMaxAge time.Duration
+	// CORSAllowCredentials lets browsers send cookies and auth headers
+	// with cross-origin requests. Only explicitly listed origins are then
+	// allowed, and others are rejected.
+	CORSAllowCredentials bool
+	// MaintenanceMode starts the service rejecting writes with 503.
+	MaintenanceMode bool
+	// MaintenanceRetryAfter is advertised in the Retry-After header of
+	// writes rejected during maintenance.
+	MaintenanceRetryAfter time.Duration
+	// ReadOnly rejects every write with 403 for good, unlike maintenance
+	// mode, for read replicas and disaster recovery. The catalog is then
+	// never saved, so a replica can share the persistence file.
+	ReadOnly bool
+	// TitleCaseAuthors rewrites author names as "Brian Kernighan" on
+	// create. It is off by default because it mangles names such as
+	// "McGavren".
+	TitleCaseAuthors bool
+	// BooksCache caches the serialized getBooks responses until the next
+	// change to the catalog. Useful for read-heavy workloads.
+	BooksCache bool
+	// MaxQueryLength is the longest raw query string accepted, in bytes.
+	// Longer ones are rejected with 414 before any parameter is parsed.
+	MaxQueryLength int
+	// ListEnvelope wraps list responses as {"data": [...], "meta": {...}}
+	// for every client instead of returning a bare array.
+	ListEnvelope bool
+	// AdminKey is the secret expected in the X-Admin-Key header of admin
+	// requests. The admin endpoints are disabled while it is empty.
+	AdminKey string
+	// PersistFile is the JSON file the catalog is saved to after every
+	// change and restored from at startup. Persistence is off when empty.
+	PersistFile string
+	// PersistGzip compresses the persistence file with gzip, which pays
+	// off for large catalogs; name it accordingly, e.g. books.json.gz.
+	// Either format is read back, so it can be switched at any time.
+	PersistGzip bool
+	// HeavyOpConcurrency is the number of bulk imports and exports that may
+	// run at the same time across all clients.
+	HeavyOpConcurrency int
+	// MinQuantity and MaxQuantity bound the number of copies of a title.
+	// Every endpoint that sets or changes a quantity enforces them.
+	MinQuantity int
+	MaxQuantity int
+	// LoanPeriod is how long a borrower may keep a copy when checkout
+	// doesn't set a due date.
+	LoanPeriod time.Duration
+	// MaxQuantityDelta is the largest change a single update may make to a
+	// quantity unless the request carries "X-Force: true". Zero disables
+	// the guard.
+	MaxQuantityDelta int
+	// CheckoutDedupWindow collapses identical checkout requests from the
+	// same client received within this window into one. Zero disables it.
+	CheckoutDedupWindow time.Duration
+	// SlowRequestThreshold is the latency above which a request is logged
+	// as slow, at WARN level. Zero disables it.
+	SlowRequestThreshold time.Duration
+	// MaxDecompressedBody is the largest size, in bytes, a gzip request
+	// body may expand to. It protects against zip bombs.
+	MaxDecompressedBody int
+	// MaxLoansPerBorrower is the number of copies a named borrower may have
+	// checked out at the same time. Admins are exempt. Zero disables it.
+	MaxLoansPerBorrower int
+	// SeedFile is a JSON file with the books the catalog starts with,
+	// replacing the built-in ones. A persisted catalog still takes
+	// precedence over it.
+	SeedFile string
+	// CacheMaxAge is how long clients may cache a book or list of books
+	// without revalidating it, advertised as "Cache-Control: private,
+	// max-age=...". Zero makes them revalidate every time, which is cheap
+	// thanks to the ETag.
+	CacheMaxAge time.Duration
+	// MaxConcurrentRequests caps the number of requests handled at the same
+	// time; the others are rejected with 503 so clients back off. Zero
+	// disables the cap.
+	MaxConcurrentRequests int
+	// BookLinks adds hypermedia _links to every single-book response
+	// instead of only for clients asking for the "links" profile.
+	BookLinks bool
+	// SigningSecrets are the shared secrets mutating requests must be
+	// signed with, as HMAC-SHA256 in the X-Signature header. Signing is off
+	// when there are none.
+	SigningSecrets []string
+	// SignatureMaxSkew is how far the X-Timestamp of a signed request may
+	// be from the server's clock before it is rejected as stale.
+	SignatureMaxSkew time.Duration
+	// DefaultQuantity is the quantity of a book created without one. An
+	// explicit quantity, including 0, is always honored.
+	DefaultQuantity int
+	// DisabledFeatures lists the optional features switched off, such as
+	// "import" or "loans". Their routes then respond like unknown routes.
+	DisabledFeatures []string
+	// StrictNumbers rejects numeric fields of a created book sent as
+	// strings, such as "quantity": "5", instead of accepting them.
+	StrictNumbers bool
+	// RateLimit is the number of requests a minute each client IP may make
+	// on average, and RateLimitBurst the number it may make at once,
+	// RateLimit when zero. A zero RateLimit disables rate limiting.
+	RateLimit      int
+	RateLimitBurst int
+	// RateLimitMode is "enforce" to reject requests over the rate limit,
+	// "warn" to only log and count them, so limits can be tuned against
+	// real traffic first, or "off".
+	RateLimitMode string
+	// SeedURL is an HTTP(S) URL the seed is fetched from at startup, like
+	// SeedFile, which it takes precedence over. SeedTimeout bounds the
+	// fetch.
+	SeedURL     string
+	SeedTimeout time.Duration
+	// CheckoutCooldown is how long a client must wait before checking out
+	// the same book again, so no one can inflate its demand by checking it
+	// out and returning it over and over. Zero disables it.
+	CheckoutCooldown time.Duration
+	// FieldTimes tracks when the title, author, quantity, genre and ISBN
+	// of each book last changed, for sync clients merging field by field.
+	// It is off by default as it stores a time per field.
+	FieldTimes bool
+	// MaxBooks caps the number of live books in the catalog, protecting
+	// memory-backed deployments from unbounded growth. Creates and imports
+	// that would exceed it are rejected with 507. Deleted books are kept
+	// only while there is room, the oldest being dropped first, so the
+	// catalog never stores more books than this. Zero means no cap.
+	MaxBooks int
+	// BasePath is the path prefix every route is served under, such as
+	// "/api" behind a reverse proxy routing /api/* to the service. It is
+	// also part of the URLs in responses. Routes are served from the root
+	// when it is empty.
+	BasePath string
+	// ShutdownTimeout is how long a shutdown waits for the requests in
+	// flight to complete before abandoning them.
+	ShutdownTimeout time.Duration
+}
+
+// cfg is the configuration in effect, loaded once in main.
+var cfg = defaultConfig()
+
+// defaultConfig returns the configuration used when no environment
+// variables are set.
+func defaultConfig() config {
+	return config{
+		CORSAllowedOrigins:    []string{"*"},
+		CORSMaxAge:            10 * time.Minute,
+		MaintenanceRetryAfter: 5 * time.Minute,
+		MaxQueryLength:        2048,
+		HeavyOpConcurrency:    2,
+		MaxQuantity:           10000,
+		LoanPeriod:            14 * 24 * time.Hour,
+		SlowRequestThreshold:  500 * time.Millisecond,
+		MaxDecompressedBody:   10 << 20,
+		MaxLoansPerBorrower:   5,
+		SignatureMaxSkew:      5 * time.Minute,
+		DefaultQuantity:       1,
+		SeedTimeout:           10 * time.Second,
+		ShutdownTimeout:       15 * time.Second,
+		RateLimitMode:         rateLimitEnforce,
+	}
+}
+
+// loadConfig builds the configuration from the environment, falling back
+// to the defaults for unset or invalid values.
+func loadConfig() config {
+	c := defaultConfig()
+	c.CORSAllowedOrigins = envList("CORS_ALLOWED_ORIGINS", c.CORSAllowedOrigins)
+	c.CORSMaxAge = envDuration("CORS_MAX_AGE", c.CORSMaxAge)
+	c.CORSAllowCredentials = envBool("CORS_ALLOW_CREDENTIALS", c.CORSAllowCredentials)
+	c.MaintenanceMode = envBool("MAINTENANCE_MODE", c.MaintenanceMode)
+	c.MaintenanceRetryAfter = envDuration("MAINTENANCE_RETRY_AFTER", c.MaintenanceRetryAfter)
+	c.ReadOnly = envBool("READ_ONLY", c.ReadOnly)
+	c.TitleCaseAuthors = envBool("TITLE_CASE_AUTHORS", c.TitleCaseAuthors)
+	c.BooksCache = envBool("BOOKS_CACHE", c.BooksCache)
+	c.MaxQueryLength = envInt("MAX_QUERY_LENGTH", c.MaxQueryLength)
+	c.ListEnvelope = envBool("LIST_ENVELOPE", c.ListEnvelope)
+	c.AdminKey = os.Getenv("ADMIN_KEY")
+	c.PersistFile = os.Getenv("PERSIST_FILE")
+	c.PersistGzip = envBool("PERSIST_GZIP", c.PersistGzip)
+	c.HeavyOpConcurrency = envInt("HEAVY_OP_CONCURRENCY", c.HeavyOpConcurrency)
+	c.MinQuantity = envInt("MIN_QUANTITY", c.MinQuantity)
+	c.MaxQuantity = envInt("MAX_QUANTITY", c.MaxQuantity)
+	c.LoanPeriod = envDuration("LOAN_PERIOD", c.LoanPeriod)
+	c.MaxQuantityDelta = envInt("MAX_QUANTITY_DELTA", c.MaxQuantityDelta)
+	c.CheckoutDedupWindow = envDuration("CHECKOUT_DEDUP_WINDOW", c.CheckoutDedupWindow)
+	c.SlowRequestThreshold = envDuration("SLOW_REQUEST_THRESHOLD", c.SlowRequestThreshold)
+	c.MaxDecompressedBody = envInt("MAX_DECOMPRESSED_BODY", c.MaxDecompressedBody)
+	c.MaxLoansPerBorrower = envInt("MAX_LOANS_PER_BORROWER", c.MaxLoansPerBorrower)
+	c.SeedFile = os.Getenv("SEED_FILE")
+	c.CacheMaxAge = envDuration("CACHE_MAX_AGE", c.CacheMaxAge)
+	c.MaxConcurrentRequests = envInt("MAX_CONCURRENT_REQUESTS", c.MaxConcurrentRequests)
+	c.BookLinks = envBool("BOOK_LINKS", c.BookLinks)
+	c.SigningSecrets = envList("SIGNING_SECRETS", c.SigningSecrets)
+	c.SignatureMaxSkew = envDuration("SIGNATURE_MAX_SKEW", c.SignatureMaxSkew)
+	c.DefaultQuantity = envInt("DEFAULT_QUANTITY", c.DefaultQuantity)
+	c.DisabledFeatures = envList("DISABLED_FEATURES", c.DisabledFeatures)
+	c.StrictNumbers = envBool("STRICT_NUMBERS", c.StrictNumbers)
+	c.RateLimit = envInt("RATE_LIMIT", c.RateLimit)
+	c.RateLimitBurst = envInt("RATE_LIMIT_BURST", c.RateLimitBurst)
+	c.RateLimitMode = envChoice("RATE_LIMIT_MODE", c.RateLimitMode, rateLimitOff, rateLimitWarn, rateLimitEnforce)
+	c.SeedURL = os.Getenv("SEED_URL")
+	c.SeedTimeout = envDuration("SEED_TIMEOUT", c.SeedTimeout)
+	c.CheckoutCooldown = envDuration("CHECKOUT_COOLDOWN", c.CheckoutCooldown)
+	c.FieldTimes = envBool("FIELD_TIMES", c.FieldTimes)
+	c.MaxBooks = envInt("MAX_BOOKS", c.MaxBooks)
+	c.ShutdownTimeout = envDuration("SHUTDOWN_TIMEOUT", c.ShutdownTimeout)
+	c.BasePath = envPath("BASE_PATH", c.BasePath)
+	return c
+}
+
+// envList reads a comma-separated list, ignoring empty entries.
+func envList(key string, def []string) []string {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// envChoice reads one of the given values.
+func envChoice(key, def string, choices ...string) string {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	if !slices.Contains(choices, v) {
+		slog.Warn("invalid value, using default", "key", key, "value", v, "default", def, "choices", choices)
+		return def
+	}
+	return v
+}
+
+// envPath reads a URL path prefix, such as "/api". A missing leading slash
+// is added and trailing slashes are removed, so "/" means no prefix.
+func envPath(key, def string) string {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	v = strings.TrimRight(strings.TrimSpace(v), "/")
+	if v != "" && !strings.HasPrefix(v, "/") {
+		v = "/" + v
+	}
+	return v
+}
+
+// envBool reads a boolean such as "true", "1" or "false".
+func envBool(key string, def bool) bool {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		slog.Warn("invalid boolean, using default", "key", key, "value", v, "default", def)
+		return def
+	}
+	return b
+}
+
+// envInt reads a non-negative integer.
+func envInt(key string, def int) int {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		slog.Warn("invalid integer, using default", "key", key, "value", v, "default", def)
+		return def
+	}
+	return n
+}
+
+// envDuration reads a Go duration such as "90s" or "10m".
+func envDuration(key string, def time.Duration) time.Duration {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		slog.Warn("invalid duration, using default", "key", key, "value", v, "default", def)
+		return def
+	}
+	return d
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// copyStatus is the state of one physical copy of a book.
+type copyStatus string
+
+const (
+	copyAvailable  copyStatus = "available"
+	copyCheckedOut copyStatus = "checked-out"
+	copyLost       copyStatus = "lost"
+	copyDamaged    copyStatus = "damaged"
+)
+
+// bookCopy is one physical copy of a book. Copy IDs are numbered from 1
+// within each book and never reused.
+type bookCopy struct {
+	ID     int        `json:"id"`
+	Status copyStatus `json:"status"`
+	// LoanID is the active loan of a checked-out copy.
+	LoanID int `json:"loan_id,omitempty"`
+}
+
+// syncCopies makes the number of available copies of b match its
+// quantity, which stays the figure clients set and read. Missing copies
+// are added as available, and surplus available copies are dropped, the
+// most recently added first. Copies in any other status are left alone.
+// The caller must hold the write lock.
+func syncCopies(b *book) {
+	available := 0
+	nextID := 1
+	for _, cp := range b.Copies {
+		if cp.Status == copyAvailable {
+			available++
+		}
+		nextID = max(nextID, cp.ID+1)
+	}
+	for ; available < b.Quantity; available++ {
+		b.Copies = append(b.Copies, bookCopy{ID: nextID, Status: copyAvailable})
+		nextID++
+	}
+	for i := len(b.Copies) - 1; i >= 0 && available > b.Quantity; i-- {
+		if b.Copies[i].Status == copyAvailable {
+			b.Copies = append(b.Copies[:i], b.Copies[i+1:]...)
+			available--
+		}
+	}
+}
+
+// syncAllCopies runs syncCopies on every book, giving copies to books
+// loaded from a seed or saved catalog that predates them.
+func syncAllCopies() {
+	booksMu.Lock()
+	defer booksMu.Unlock()
+	for i := range books {
+		syncCopies(&books[i])
+	}
+}
+
+// findCopy returns the copy of b with the given ID.
+func findCopy(b *book, id int) (*bookCopy, bool) {
+	for i := range b.Copies {
+		if b.Copies[i].ID == id {
+			return &b.Copies[i], true
+		}
+	}
+	return nil, false
+}
+
+// firstAvailableCopy returns the available copy of b with the lowest ID.
+func firstAvailableCopy(b *book) (*bookCopy, bool) {
+	for i := range b.Copies {
+		if b.Copies[i].Status == copyAvailable {
+			return &b.Copies[i], true
+		}
+	}
+	return nil, false
+}
+
+// getBookCopies handles the HTTP request for the copies of a book and the
+// status of each. It responds with 404 Not Found when the book doesn't
+// exist.
+func getBookCopies(c *gin.Context) {
+	id, err := parseBookID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errInvalidBookID.body())
+		return
+	}
+
+	booksMu.RLock()
+	defer booksMu.RUnlock()
+	book, err := getBookById(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errBookNotFound.body())
+		return
+	}
+	copies := append([]bookCopy{}, book.Copies...)
+	renderJSON(c, http.StatusOK, copies)
+}
+
+// availability is the stock of a book, as returned by getAvailability.
+type availability struct {
+	ID        int64 `json:"id"`
+	Available bool  `json:"available"`
+	Quantity  int   `json:"quantity"`
+}
+
+// getAvailability handles the HTTP request for the stock of a book alone,
+// which is cheaper than the whole book for clients polling it, such as a
+// product page. It responds with 404 Not Found when the book doesn't exist.
+func getAvailability(c *gin.Context) {
+	id, err := parseBookID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errInvalidBookID.body())
+		return
+	}
+
+	booksMu.RLock()
+	defer booksMu.RUnlock()
+	book, err := getBookById(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errBookNotFound.body())
+		return
+	}
+	renderJSON(c, http.StatusOK, availability{ID: book.ID, Available: book.Quantity > 0, Quantity: book.Quantity})
+}
+
+// maxReasonLength is the longest write-off reason accepted, in bytes.
+const maxReasonLength = 500
+
+// writeOffRequest is the optional payload of the damage and lost
+// endpoints.
+type writeOffRequest struct {
+	// Copy is the ID of the copy written off. The available copy with the
+	// lowest ID is used when it is omitted.
+	Copy   int    `json:"copy"`
+	Reason string `json:"reason"`
+}
+
+// writeOffCopy returns a handler writing off an available copy of a book
+// with the given status, copyDamaged or copyLost. The copy leaves the
+// available stock for good, unlike a checkout it is never returned, so the
+// book's quantity goes down by one. The change is recorded in the audit log
+// with the reason from the optional payload, e.g. {"reason": "water damage"}.
+//
+// It responds with 400 Bad Request for malformed JSON, 422 Unprocessable
+// Entity for a reason that is too long or when the quantity would fall
+// below the configured minimum, 404 Not Found when the book or copy doesn't
+// exist, 409 Conflict when the copy, or every copy, isn't available, and
+// 200 OK with the written-off copy otherwise.
+func writeOffCopy(status copyStatus) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := parseBookID(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errInvalidBookID.body())
+			return
+		}
+		var req writeOffRequest
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(http.StatusBadRequest, errInvalidJSON.body())
+			return
+		}
+		req.Reason = strings.TrimSpace(req.Reason)
+		if len(req.Reason) > maxReasonLength {
+			c.JSON(http.StatusUnprocessableEntity, invalidRequest("Reason too long").body())
+			return
+		}
+
+		booksMu.Lock()
+		defer booksMu.Unlock()
+		book, err := getBookById(id)
+		if err != nil {
+			c.JSON(http.StatusNotFound, errBookNotFound.body())
+			return
+		}
+		var cp *bookCopy
+		var ok bool
+		if req.Copy != 0 {
+			if cp, ok = findCopy(book, req.Copy); !ok {
+				c.JSON(http.StatusNotFound, errCopyNotFound.body())
+				return
+			}
+			if cp.Status != copyAvailable {
+				c.JSON(http.StatusConflict, copyUnavailableError(cp).body())
+				return
+			}
+		} else if cp, ok = firstAvailableCopy(book); !ok {
+			c.JSON(http.StatusConflict, errBookNotAvailable.body())
+			return
+		}
+		if err := checkQuantity(book.Quantity - 1); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, errorBody(err))
+			return
+		}
+		now := time.Now().UTC()
+		cp.Status = status
+		adjustQuantity(book, -1, string(status), now)
+		book.UpdatedAt = now
+		recordAuditReason(c, string(status), book.ID, req.Reason)
+		catalogChanged()
+		renderJSON(c, http.StatusOK, *cp)
+	}
+}
+
+// shrinkage is the number of copies of a book written off as damaged or
+// lost, as reported by getShrinkage.
+type shrinkage struct {
+	BookID  int64  `json:"book_id"`
+	Title   string `json:"title"`
+	Damaged int    `json:"damaged"`
+	Lost    int    `json:"lost"`
+}
+
+// getShrinkage handles the HTTP request for the inventory shrinkage report:
+// the number of damaged and lost copies of every live book that has any,
+// most copies written off first, then by book ID.
+func getShrinkage(c *gin.Context) {
+	report := []shrinkage{}
+	booksMu.RLock()
+	for _, b := range books {
+		if b.isDeleted() {
+			continue
+		}
+		s := shrinkage{BookID: b.ID, Title: b.Title}
+		for _, cp := range b.Copies {
+			switch cp.Status {
+			case copyDamaged:
+				s.Damaged++
+			case copyLost:
+				s.Lost++
+			}
+		}
+		if s.Damaged+s.Lost > 0 {
+			report = append(report, s)
+		}
+	}
+	booksMu.RUnlock()
+
+	slices.SortStableFunc(report, func(a, b shrinkage) int {
+		if n := (b.Damaged + b.Lost) - (a.Damaged + a.Lost); n != 0 {
+			return n
+		}
+		return cmp.Compare(a.BookID, b.BookID)
+	})
+	renderJSON(c, http.StatusOK, report)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dedupEntry is the outcome of the first of a series of identical
+// requests. done is closed once the response has been recorded.
+type dedupEntry struct {
+	done    chan struct{}
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// recordingWriter copies everything written to the response so it can be
+// replayed for duplicate requests.
+type recordingWriter struct {
+	gin.ResponseWriter
+	buf bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.buf.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.buf.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// dedupCheckout collapses identical checkout requests, from the same client
+// IP for the same book, count, borrower and due date, received within window
+// of each other, whether the parameters come in the query string, as for
+// checkoutBook, or in a JSON body, as for checkoutJSON. Only
+// the first one is handled; the duplicates wait for it and get the same
+// response, marked with an X-Deduplicated header, so a double click or a
+// retry on a flaky network doesn't take two copies. A zero window turns
+// deduplication off.
+func dedupCheckout(window time.Duration) gin.HandlerFunc {
+	if window <= 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+	var mu sync.Mutex
+	entries := map[string]*dedupEntry{}
+
+	return func(c *gin.Context) {
+		key := c.ClientIP() + "|" + checkoutKey(c)
+		now := time.Now()
+
+		mu.Lock()
+		for k, e := range entries {
+			if !e.expires.IsZero() && now.After(e.expires) {
+				delete(entries, k)
+			}
+		}
+		if e, ok := entries[key]; ok {
+			mu.Unlock()
+			<-e.done
+			for name, values := range e.header {
+				c.Writer.Header()[name] = values
+			}
+			c.Header("X-Deduplicated", "true")
+			c.Status(e.status)
+			c.Writer.Write(e.body)
+			c.Abort()
+			return
+		}
+		e := &dedupEntry{done: make(chan struct{})}
+		entries[key] = e
+		mu.Unlock()
+
+		rec := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = rec
+		defer func() {
+			mu.Lock()
+			e.status = rec.Status()
+			e.header = rec.Header().Clone()
+			e.body = rec.buf.Bytes()
+			e.expires = time.Now().Add(window)
+			mu.Unlock()
+			close(e.done)
+		}()
+		c.Next()
+	}
+}
+
+// checkoutKey returns the parameters of a checkout request that make it
+// distinct, read from the query string of a GET and from the JSON body
+// otherwise. The body is restored for the handler. A body that isn't a
+// valid checkout is used as is, so the handler rejects it each time.
+func checkoutKey(c *gin.Context) string {
+	if c.Request.Method == http.MethodGet {
+		return strings.Join([]string{c.Query("id"), c.Query("isbn"), c.Query("copy"), c.Query("receipt"), c.DefaultQuery("count", "1"), c.Query("borrower"), c.Query("due_date")}, "|")
+	}
+	data, err := c.GetRawData()
+	if err != nil {
+		return ""
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(data))
+	req := checkoutRequest{Count: 1}
+	if json.Unmarshal(data, &req) != nil {
+		return string(data)
+	}
+	id := ""
+	if req.ID != nil {
+		id = strconv.FormatInt(*req.ID, 10)
+	}
+	return strings.Join([]string{id, req.ISBN, strconv.Itoa(req.Copy), strconv.FormatBool(req.Receipt), strconv.Itoa(req.Count), req.Borrower, req.DueDate}, "|")
+}
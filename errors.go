@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiError is an error reported to clients with a stable, machine-readable
+// code alongside the human-readable message, so they can branch on the code
+// regardless of the wording.
+type apiError struct {
+	Code    string
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+// body returns the JSON body reporting e, {"code": ..., "message": ...}.
+// Callers may add details to it.
+func (e *apiError) body() gin.H {
+	return gin.H{"code": e.Code, "message": e.Message}
+}
+
+// The errors about books shared by every handler. Their codes are part of
+// the API and must never change.
+var (
+	errBookNotFound      = &apiError{Code: "book_not_found", Message: "Book not found."}
+	errBookNotAvailable  = &apiError{Code: "book_not_available", Message: "Book not available."}
+	errBookReferenceOnly = &apiError{Code: "book_reference_only", Message: "Book is for reference only and can't be checked out."}
+	errCopyNotFound      = &apiError{Code: "copy_not_found", Message: "Copy not found."}
+	errInvalidBookID     = &apiError{Code: "invalid_id", Message: "Invalid ID"}
+	errInvalidJSON       = &apiError{Code: "invalid_json", Message: "Invalid JSON"}
+	errInvalidLoanID     = &apiError{Code: "invalid_loan_id", Message: "Invalid loan ID"}
+	errLoanNotFound      = &apiError{Code: "loan_not_found", Message: "Loan not found"}
+	errLoanReturned      = &apiError{Code: "loan_already_returned", Message: "Loan already returned"}
+)
+
+// The errors of the service as a whole rather than of a book. Their codes
+// are part of the API too.
+var (
+	errRouteNotFound = &apiError{Code: "route_not_found", Message: "Route not found"}
+	errReadOnly      = &apiError{Code: "read_only", Message: "Service is read-only."}
+	errMaintenance   = &apiError{Code: "maintenance", Message: "Service is under maintenance, writes are temporarily disabled."}
+	errInternal      = &apiError{Code: "internal_error", Message: "Internal error"}
+)
+
+// loanLimitError reports a borrower who already has n books checked out,
+// which with the requested ones would exceed the limit.
+func loanLimitError(n, limit int) *apiError {
+	return &apiError{Code: "loan_limit_reached", Message: fmt.Sprintf("Borrower already has %d books checked out, the limit is %d", n, limit)}
+}
+
+// copyUnavailableError reports a copy that can't be checked out or written
+// off because it isn't available.
+func copyUnavailableError(cp *bookCopy) *apiError {
+	return &apiError{Code: "copy_not_available", Message: "Copy is " + string(cp.Status) + "."}
+}
+
+// invalidRequest returns an error with the generic code invalid_request for
+// a request that is malformed in a way no more specific code covers.
+func invalidRequest(message string) *apiError {
+	return &apiError{Code: "invalid_request", Message: message}
+}
+
+// errorCode returns the code reporting err: that of the apiError it wraps,
+// invalid_number for a numeric field decodeNewBook rejected, catalog_full
+// for a catalogFullError, and invalid_request for any other error.
+func errorCode(err error) string {
+	var apiErr *apiError
+	var numErr *numberError
+	var full *catalogFullError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr.Code
+	case errors.As(err, &numErr):
+		return "invalid_number"
+	case errors.As(err, &full):
+		return "catalog_full"
+	}
+	return "invalid_request"
+}
+
+// errorBody returns the JSON body reporting err, {"code": ..., "message":
+// ...}, with the code from errorCode. Context added while wrapping err, such
+// as the line of an import, stays in the message.
+func errorBody(err error) gin.H {
+	return gin.H{"code": errorCode(err), "message": err.Error()}
+}
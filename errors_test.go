@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// TestErrorCodes pins the code of every kind of book error. The codes are
+// part of the API: a failure here means clients branching on them break.
+func TestErrorCodes(t *testing.T) {
+	tests := []struct {
+		method, target, body string
+		status               int
+		code                 string
+	}{
+		{http.MethodGet, "/books/99", "", http.StatusNotFound, "book_not_found"},
+		{http.MethodGet, "/books/abc", "", http.StatusBadRequest, "invalid_id"},
+		{http.MethodPut, "/books/abc", `{}`, http.StatusBadRequest, "invalid_id"},
+		{http.MethodGet, "/checkout?id=abc", "", http.StatusBadRequest, "invalid_id"},
+		{http.MethodPost, "/books", `{"id": 1,`, http.StatusBadRequest, "invalid_json"},
+		{http.MethodPut, "/books/1", `[`, http.StatusBadRequest, "invalid_json"},
+		{http.MethodPost, "/books", `{"id": 10, "title": "T", "author": "A", "quantity": "many"}`, http.StatusUnprocessableEntity, "invalid_number"},
+		{http.MethodPost, "/books", `{"id": 10, "title": "", "author": "A"}`, http.StatusUnprocessableEntity, "empty_title"},
+		{http.MethodPost, "/books", `{"id": 10, "title": "T", "author": "A", "isbn": "123"}`, http.StatusUnprocessableEntity, "invalid_isbn"},
+		{http.MethodPost, "/books", `{"id": 10, "title": "T", "author": "A", "quantity": -1}`, http.StatusUnprocessableEntity, "quantity_out_of_range"},
+		{http.MethodPost, "/books", `{"id": 10, "title": "T", "author": "A", "tags": ["no spaces"]}`, http.StatusUnprocessableEntity, "invalid_tag"},
+		{http.MethodPost, "/books", `{"id": 9007199254740992, "title": "T", "author": "A"}`, http.StatusUnprocessableEntity, "id_out_of_range"},
+		{http.MethodPost, "/books", `{"id": 1, "title": "T", "author": "A"}`, http.StatusConflict, "duplicate_id"},
+		{http.MethodPost, "/books", `{"id": 10, "title": "T", "author": "A", "isbn": "9780134190440"}`, http.StatusConflict, "duplicate_isbn"},
+		{http.MethodPut, "/books/2", `{"title": "T", "author": "A", "isbn": "9780134190440"}`, http.StatusConflict, "duplicate_isbn"},
+		{http.MethodPost, "/books/1/restock", `{"count": 0}`, http.StatusUnprocessableEntity, "invalid_request"},
+		{http.MethodGet, "/checkout?id=1&count=3", "", http.StatusBadRequest, "book_not_available"},
+		{http.MethodGet, "/checkout?id=1&copy=99", "", http.StatusNotFound, "copy_not_found"},
+		{http.MethodPost, "/loans/abc/return", "", http.StatusBadRequest, "invalid_loan_id"},
+		{http.MethodPost, "/loans/99/return", "", http.StatusNotFound, "loan_not_found"},
+	}
+	r := newTestRouter(t, nil)
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			wantError(t, do(r, tt.method, tt.target, tt.body), tt.status, tt.code)
+		})
+	}
+}
+
+func TestErrorCodeReferenceOnly(t *testing.T) {
+	r := newTestRouter(t, nil)
+	mustCreate(t, r, `{"id": 10, "title": "T", "author": "A", "checkoutable": false}`)
+	wantError(t, do(r, http.MethodGet, "/checkout?id=10", ""), http.StatusForbidden, "book_reference_only")
+}
+
+func TestErrorCodeLoanReturned(t *testing.T) {
+	r := newTestRouter(t, nil)
+	w := do(r, http.MethodGet, "/checkout?id=1", "")
+	loan := w.Header().Get("X-Loan-ID")
+	if w.Code != http.StatusOK || loan == "" {
+		t.Fatalf("checkout: status %d, X-Loan-ID %q", w.Code, loan)
+	}
+	if w := do(r, http.MethodPost, "/loans/"+loan+"/return", ""); w.Code != http.StatusOK {
+		t.Fatalf("return: status %d, body %s", w.Code, w.Body)
+	}
+	wantError(t, do(r, http.MethodPost, "/loans/"+loan+"/return", ""), http.StatusConflict, "loan_already_returned")
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// csvHeader lists the columns of the CSV export, in order.
+var csvHeader = []string{"id", "title", "author", "genre", "isbn", "quantity", "available", "checkoutable", "created_at", "updated_at"}
+
+// exportCSV handles the HTTP request to export the catalog as CSV. Rows are
+// written straight to the response as they are produced, with one header
+// row first, and stop if the client goes away. Deleted books are not
+// exported.
+//
+// The export honors the same filters as getBooks, such as `author`, `genre`,
+// `available`, `id_from`, `id_to` and `since`, so it can report on a subset
+// such as one author's books or the titles out of stock. The applied
+// filters are reflected in the file name, e.g. books-author-kernighan.csv.
+func exportCSV(c *gin.Context) {
+	q, err := parseListQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorBody(err))
+		return
+	}
+
+	booksMu.RLock()
+	defer booksMu.RUnlock()
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="`+exportFilename(q)+`.csv"`)
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write(csvHeader)
+	for i, b := range books {
+		if i%cancelCheckEvery == 0 && c.Request.Context().Err() != nil {
+			return
+		}
+		if !q.matches(b) || b.isDeleted() {
+			continue
+		}
+		w.Write([]string{
+			strconv.FormatInt(b.ID, 10),
+			b.Title,
+			b.Author,
+			b.Genre,
+			b.ISBN,
+			strconv.Itoa(b.Quantity),
+			strconv.FormatBool(b.Quantity > 0),
+			strconv.FormatBool(b.Checkoutable),
+			b.CreatedAt.Format(time.RFC3339),
+			b.UpdatedAt.Format(time.RFC3339),
+		})
+	}
+	w.Flush()
+}
+
+// exportFilename returns the base name of an export file, with the filters
+// of q appended so different subsets don't overwrite each other.
+func exportFilename(q listQuery) string {
+	name := "books"
+	if q.Author != "" {
+		name += "-author-" + slugify(q.Author)
+	}
+	if q.Genre != "" {
+		name += "-genre-" + slugify(q.Genre)
+	}
+	if q.Available != nil {
+		if *q.Available {
+			name += "-available"
+		} else {
+			name += "-unavailable"
+		}
+	}
+	if q.IDFrom != nil {
+		name += "-id-from-" + strconv.FormatInt(*q.IDFrom, 10)
+	}
+	if q.IDTo != nil {
+		name += "-id-to-" + strconv.FormatInt(*q.IDTo, 10)
+	}
+	if q.Since != nil {
+		name += "-since-" + q.Since.UTC().Format("20060102T150405Z")
+	}
+	return name
+}
+
+// slugify lower-cases s and replaces every run of characters other than
+// ASCII letters and digits with a single hyphen, making it safe to use in a
+// file name.
+func slugify(s string) string {
+	var sb strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			sb.WriteRune(r)
+			hyphen = false
+		} else if !hyphen && sb.Len() > 0 {
+			sb.WriteByte('-')
+			hyphen = true
+		}
+	}
+	return strings.TrimSuffix(sb.String(), "-")
+}
+
+// ndjsonFlushEvery is the number of NDJSON lines written between flushes.
+const ndjsonFlushEvery = 100
+
+// exportNDJSON handles the HTTP request to export the catalog as JSON
+// Lines: one JSON book per line, in the same representation as getBooks.
+// Lines are encoded one at a time and flushed as they go, so memory use
+// stays flat however large the catalog is, and the export stops if the
+// client goes away. Deleted books are not exported.
+func exportNDJSON(c *gin.Context) {
+	booksMu.RLock()
+	defer booksMu.RUnlock()
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", `attachment; filename="books.ndjson"`)
+	c.Status(http.StatusOK)
+
+	enc := json.NewEncoder(c.Writer)
+	written := 0
+	for i, b := range books {
+		if i%cancelCheckEvery == 0 && c.Request.Context().Err() != nil {
+			return
+		}
+		if b.isDeleted() {
+			continue
+		}
+		if err := enc.Encode(newBookResponse(b)); err != nil {
+			return
+		}
+		if written++; written%ndjsonFlushEvery == 0 {
+			c.Writer.Flush()
+		}
+	}
+	c.Writer.Flush()
+}
+
+// importCSV handles the HTTP request to create books in bulk from CSV.
+// The first row is a header naming the columns; id, title and author are
+// required, while quantity (the configured DefaultQuantity when empty),
+// genre, isbn and checkoutable (true by default) are optional and other
+// columns, such as those of exportCSV, are ignored.
+//
+// The import is all or nothing: every row is validated like a single
+// create before any book is added. A malformed CSV is rejected with 400
+// Bad Request. On the first invalid row it responds with 422 Unprocessable
+// Entity, or 409 Conflict for a duplicate ID or ISBN, naming the line. When
+// the books would take the catalog past the configured MaxBooks, it
+// responds with 507 Insufficient Storage. Otherwise it responds with 201
+// Created and the number of books imported.
+func importCSV(c *gin.Context) {
+	parsed, err := parseCSVBooks(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorBody(err))
+		return
+	}
+
+	booksMu.Lock()
+	defer booksMu.Unlock()
+	seenIDs := map[int64]bool{}
+	seenISBNs := map[string]bool{}
+	for i, b := range parsed {
+		line := i + 2
+		err := validateNewBook(b)
+		if err == nil && seenIDs[b.ID] {
+			err = errDuplicateID
+		}
+		if err == nil && b.ISBN != "" && seenISBNs[b.ISBN] {
+			err = errDuplicateISBN
+		}
+		if err != nil {
+			c.JSON(validationStatus(err), errorBody(fmt.Errorf("line %d: %w", line, err)))
+			return
+		}
+		seenIDs[b.ID] = true
+		seenISBNs[b.ISBN] = true
+	}
+	if full := checkCapacity(len(parsed)); full != nil {
+		c.JSON(http.StatusInsufficientStorage, full.body())
+		return
+	}
+
+	now := time.Now().UTC()
+	for i := range parsed {
+		insertBook(&parsed[i], now, "import")
+		recordAudit(c, "import", parsed[i].ID)
+	}
+	if len(parsed) > 0 {
+		catalogChanged()
+	}
+	renderJSON(c, http.StatusCreated, gin.H{"imported": len(parsed)})
+}
+
+// parseCSVBooks reads the books of a CSV import and normalizes them. It
+// checks the format of every row but not whether the books can be added.
+func parseCSVBooks(r io.Reader) ([]book, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("CSV is empty, expected a header row")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+	cols := map[string]int{}
+	for i, name := range header {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"id", "title", "author"} {
+		if _, ok := cols[required]; !ok {
+			return nil, fmt.Errorf("CSV header is missing the %q column", required)
+		}
+	}
+
+	var parsed []book
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return parsed, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		field := func(name string) string {
+			if i, ok := cols[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		b := book{Checkoutable: true, Quantity: cfg.DefaultQuantity}
+		if b.ID, err = parseBookID(field("id")); err != nil {
+			return nil, fmt.Errorf("line %d: invalid id", line)
+		}
+		if q := field("quantity"); q != "" {
+			if b.Quantity, err = strconv.Atoi(q); err != nil {
+				return nil, fmt.Errorf("line %d: invalid quantity", line)
+			}
+		}
+		if s := field("checkoutable"); s != "" {
+			if b.Checkoutable, err = strconv.ParseBool(s); err != nil {
+				return nil, fmt.Errorf("line %d: invalid checkoutable", line)
+			}
+		}
+		b.Title = field("title")
+		b.Author = field("author")
+		b.Genre = field("genre")
+		b.ISBN = field("isbn")
+		normalizeBook(&b)
+		parsed = append(parsed, b)
+	}
+}
+
+// maxNDJSONLine is the longest line accepted by importNDJSON, in bytes.
+const maxNDJSONLine = 1 << 20
+
+// ndjsonResult is the outcome of one line of an NDJSON import.
+type ndjsonResult struct {
+	Line   int   `json:"line"`
+	ID     int64 `json:"id,omitempty"`
+	Status int   `json:"status"`
+	// Code and Message report why the line failed, as the body of a
+	// single create would.
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// importNDJSON handles the HTTP request to create books in bulk from JSON
+// Lines: one JSON book per line, as for a single create and as written by
+// exportNDJSON. Blank lines are skipped.
+//
+// Unlike importCSV, each line is validated and created on its own, so a bad
+// line doesn't stop the others. The response streams back one JSON result
+// per line as it is processed, with the status a single create would have
+// returned, such as 201, 409 or 422, and ends with a summary line giving
+// the number of books that succeeded and failed. Lines are read and applied
+// in batches, so memory use stays flat however large the import is. The
+// catalog is persisted once, at the end, rather than after every batch,
+// which would rewrite the whole file again and again.
+func importNDJSON(c *gin.Context) {
+	// Results are written while the body is still being read, which HTTP/1
+	// only allows in full-duplex mode.
+	http.NewResponseController(c.Writer).EnableFullDuplex()
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+	enc := json.NewEncoder(c.Writer)
+
+	sc := bufio.NewScanner(c.Request.Body)
+	sc.Buffer(nil, maxNDJSONLine)
+	succeeded, failed := 0, 0
+	line := 0
+	for done := false; !done; {
+		var batch []ndjsonResult
+		var parsed []book
+		for len(batch) < ndjsonFlushEvery {
+			if !sc.Scan() {
+				done = true
+				break
+			}
+			line++
+			data := bytes.TrimSpace(sc.Bytes())
+			if len(data) == 0 {
+				continue
+			}
+			b, err := decodeNewBook(data)
+			if err != nil {
+				status, err := decodeStatus(err)
+				batch = append(batch, ndjsonResult{Line: line, Status: status, Code: errorCode(err), Message: err.Error()})
+				parsed = append(parsed, book{})
+				continue
+			}
+			batch = append(batch, ndjsonResult{Line: line, ID: b.ID})
+			parsed = append(parsed, b)
+		}
+
+		booksMu.Lock()
+		now := time.Now().UTC()
+		count := liveBookCount()
+		pending := 0
+		for _, r := range batch {
+			if r.Status == 0 {
+				pending++
+			}
+		}
+		makeRoom(pending)
+		created := 0
+		for i := range batch {
+			r := &batch[i]
+			if r.Status != 0 {
+				continue
+			}
+			if err := validateNewBook(parsed[i]); err != nil {
+				r.Status, r.Code, r.Message = validationStatus(err), errorCode(err), err.Error()
+				continue
+			}
+			if full := capacityError(count, 1); full != nil {
+				r.Status, r.Code, r.Message = http.StatusInsufficientStorage, errorCode(full), full.Error()
+				continue
+			}
+			insertBook(&parsed[i], now, "import")
+			recordAudit(c, "import", parsed[i].ID)
+			r.Status = http.StatusCreated
+			count++
+			created++
+		}
+		if created > 0 {
+			// Readers see the new books right away, but the catalog is
+			// only persisted once the import is done; see below.
+			booksCache.clear()
+		}
+		booksMu.Unlock()
+
+		for _, r := range batch {
+			if r.Status == http.StatusCreated {
+				succeeded++
+			} else {
+				failed++
+			}
+			enc.Encode(r)
+		}
+		c.Writer.Flush()
+	}
+	if succeeded > 0 {
+		booksMu.Lock()
+		catalogChanged()
+		booksMu.Unlock()
+	}
+	if err := sc.Err(); err != nil {
+		failed++
+		enc.Encode(ndjsonResult{Line: line + 1, Status: http.StatusBadRequest, Code: "invalid_ndjson", Message: "Invalid NDJSON: " + err.Error()})
+	}
+	enc.Encode(gin.H{"succeeded": succeeded, "failed": failed})
+}
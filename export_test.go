@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// flushRecorder is a ResponseRecorder noting the number of lines written
+// at each flush.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushedAt []int
+}
+
+func (r *flushRecorder) Flush() {
+	r.flushedAt = append(r.flushedAt, bytes.Count(r.Body.Bytes(), []byte("\n")))
+	r.ResponseRecorder.Flush()
+}
+
+func TestExportNDJSONLarge(t *testing.T) {
+	const n = 25000
+	r := newTestRouter(t, nil)
+	seedCatalog(n, 1)
+	if w := do(r, http.MethodDelete, "/books/7", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE /books/7: status %d", w.Code)
+	}
+
+	w := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/books/export.ndjson", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", w.Code, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Content-Type %q, want application/x-ndjson", ct)
+	}
+
+	// Every live book is on its own line, in order.
+	sc := bufio.NewScanner(w.Body)
+	want := int64(1)
+	for sc.Scan() {
+		var b book
+		if err := json.Unmarshal(sc.Bytes(), &b); err != nil {
+			t.Fatalf("line %q: %v", sc.Text(), err)
+		}
+		if want == 7 {
+			want++
+		}
+		if b.ID != want {
+			t.Fatalf("book %d exported, want %d", b.ID, want)
+		}
+		want++
+	}
+	if want != n+1 {
+		t.Errorf("exported up to book %d, want %d", want-1, n)
+	}
+
+	// The lines are flushed as they go rather than all at the end.
+	last := 0
+	for _, lines := range w.flushedAt {
+		if lines-last > ndjsonFlushEvery {
+			t.Fatalf("%d lines written between flushes, want at most %d", lines-last, ndjsonFlushEvery)
+		}
+		last = lines
+	}
+	if last != n-1 {
+		t.Errorf("%d lines flushed, want %d", last, n-1)
+	}
+}
+
+func TestImportCSVDefaultQuantity(t *testing.T) {
+	r := newTestRouter(t, func(c *config) { c.DefaultQuantity = 3 })
+	csv := "id,title,author,quantity\n10,T,A,\n11,T,A,0\n12,T,A,5\n"
+	req := httptest.NewRequest(http.MethodPost, "/books/import", strings.NewReader(csv))
+	req.Header.Set("Content-Type", "text/csv")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status %d, body %s", w.Code, w.Body)
+	}
+	for id, want := range map[int64]int{10: 3, 11: 0, 12: 5} {
+		b, err := getBookById(id)
+		if err != nil {
+			t.Fatalf("book %d not imported", id)
+		}
+		if b.Quantity != want {
+			t.Errorf("book %d: quantity %d, want %d", id, b.Quantity, want)
+		}
+	}
+}
+
+// batchReader yields its chunks one Read at a time, calling before, if
+// set, ahead of each one but the first.
+type batchReader struct {
+	chunks [][]byte
+	before func()
+	read   int
+}
+
+func (r *batchReader) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	if r.read > 0 && r.before != nil {
+		r.before()
+	}
+	n := copy(p, r.chunks[0])
+	if r.chunks[0] = r.chunks[0][n:]; len(r.chunks[0]) == 0 {
+		r.chunks = r.chunks[1:]
+		r.read++
+	}
+	return n, nil
+}
+
+func TestImportNDJSONPersistsOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "books.json")
+	r := newTestRouter(t, func(c *config) { c.PersistFile = path })
+	var chunks [][]byte
+	for batch := range 5 {
+		var buf bytes.Buffer
+		for i := range ndjsonFlushEvery {
+			fmt.Fprintf(&buf, "{\"id\": %d, \"title\": \"T\", \"author\": \"A\"}\n", 10+batch*ndjsonFlushEvery+i)
+		}
+		chunks = append(chunks, buf.Bytes())
+	}
+	body := &batchReader{chunks: chunks, before: func() {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("catalog persisted before the import is done: %v", err)
+		}
+	}}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/books/import.ndjson", body))
+	if !bytes.HasSuffix(w.Body.Bytes(), []byte(`{"failed":0,"succeeded":500}`+"\n")) {
+		t.Fatalf("import summary missing from %s", w.Body.Bytes()[max(0, w.Body.Len()-100):])
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("catalog not persisted after the import: %v", err)
+	}
+	saved, err := decodeCatalog(data)
+	if err != nil || len(saved.Books) != 503 {
+		t.Errorf("persisted %d books, %v, want 503", len(saved.Books), err)
+	}
+}
+
+func TestImportNDJSONCapacity(t *testing.T) {
+	r := newTestRouter(t, func(c *config) { c.MaxBooks = 5 })
+	body := "{\"id\": 10, \"title\": \"T\", \"author\": \"A\"}\n" +
+		"{\"id\": 11, \"title\": \"T\", \"author\": \"A\"}\n" +
+		"{\"id\": 12, \"title\": \"T\", \"author\": \"A\"}\n"
+	w := do(r, http.MethodPost, "/books/import.ndjson", body)
+	var statuses []int
+	for _, line := range bytes.Split(bytes.TrimSpace(w.Body.Bytes()), []byte("\n")) {
+		var res ndjsonResult
+		json.Unmarshal(line, &res)
+		if res.Line > 0 {
+			statuses = append(statuses, res.Status)
+		}
+	}
+	// The catalog holds 3 books, so only 2 more fit.
+	want := []int{http.StatusCreated, http.StatusCreated, http.StatusInsufficientStorage}
+	if !slices.Equal(statuses, want) {
+		t.Errorf("statuses %v, want %v", statuses, want)
+	}
+}
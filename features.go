@@ -0,0 +1,99 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// features lists the optional features that can be switched off with the
+// DISABLED_FEATURES variable. All of them are on by default.
+var features = []string{"export", "import", "ledger", "loans", "patch", "tags", "write-offs"}
+
+// routeFeatures maps the routes of each optional feature, keyed by method
+// and route like routeMutates, to the feature.
+var routeFeatures = map[string]string{
+	"GET /books/export.csv":        "export",
+	"GET /books/export.ndjson":     "export",
+	"POST /books/import":           "import",
+	"POST /books/import.ndjson":    "import",
+	"GET /books/:id/ledger":        "ledger",
+	"GET /books/never-checked-out": "ledger",
+	"GET /checkout":                "loans",
+	"POST /checkout":               "loans",
+	"GET /books/:id/loans":         "loans",
+	"POST /books/:id/transfer":     "loans",
+	"GET /loans/overdue":           "loans",
+	"POST /loans/:id/return":       "loans",
+	"POST /return/batch":           "loans",
+	"PATCH /books/:id":             "patch",
+	"POST /books/tags":             "tags",
+	"POST /books/:id/damage":       "write-offs",
+	"POST /books/:id/lost":         "write-offs",
+	"GET /books/shrinkage":         "write-offs",
+}
+
+// featureEnabled reports whether the optional feature is on.
+func featureEnabled(name string) bool {
+	return !slices.Contains(cfg.DisabledFeatures, name)
+}
+
+// routeEnabled reports whether the route, keyed by method and route, is
+// served, that is whether it belongs to no feature or to an enabled one.
+func routeEnabled(key string) bool {
+	name, ok := routeFeatures[key]
+	return !ok || featureEnabled(name)
+}
+
+// warnUnknownFeatures logs the disabled features that don't exist, which
+// are most likely typos.
+func warnUnknownFeatures() {
+	for _, name := range cfg.DisabledFeatures {
+		if !slices.Contains(features, name) {
+			slog.Warn("unknown feature in DISABLED_FEATURES", "feature", name)
+		}
+	}
+}
+
+// gateFeatures answers the routes of disabled features exactly like routes
+// that don't exist, with 404 Not Found, so clients see one consistent
+// behavior whichever feature is off.
+func gateFeatures() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !routeEnabled(c.Request.Method + " " + routeOf(c)) {
+			routeNotFound(c)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// getFeatures handles the admin request for the state of every optional
+// feature, along with the behaviors switched on by their own settings,
+// so operators can see what is active.
+func getFeatures(c *gin.Context) {
+	enabled := gin.H{}
+	for _, name := range features {
+		enabled[name] = featureEnabled(name)
+	}
+	rateLimit := cfg.RateLimitMode
+	if cfg.RateLimit == 0 {
+		rateLimit = rateLimitOff
+	}
+	renderJSON(c, http.StatusOK, gin.H{
+		"features": enabled,
+		"settings": gin.H{
+			"persistence":   cfg.PersistFile != "",
+			"signing":       len(cfg.SigningSecrets) > 0,
+			"books_cache":   cfg.BooksCache,
+			"list_envelope": cfg.ListEnvelope,
+			"book_links":    cfg.BookLinks,
+			"maintenance":   maintenanceMode.Load(),
+			"read_only":     cfg.ReadOnly,
+			"rate_limit":    rateLimit,
+		},
+	})
+}
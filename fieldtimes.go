@@ -0,0 +1,50 @@
+package main
+
+import "time"
+
+// fieldTimesProfile is the Accept profile with which a client asks for the
+// last modification time of each field in single-book responses, e.g.
+// `Accept: application/json; profile="field-times"`.
+const fieldTimesProfile = "field-times"
+
+// trackedFields are the fields of a book whose modification times are
+// tracked when FieldTimes is configured, by their JSON names.
+var trackedFields = []string{"title", "author", "quantity", "genre", "isbn"}
+
+// bookMeta holds the details about a book returned under _meta in
+// single-book responses.
+type bookMeta struct {
+	// FieldTimes is the last modification time of each tracked field.
+	// Fields not changed since tracking was turned on are missing.
+	FieldTimes map[string]time.Time `json:"field_times"`
+}
+
+// touchField records that the field of b changed at the given time. It
+// does nothing unless FieldTimes is configured. The caller must hold the
+// write lock.
+func touchField(b *book, field string, at time.Time) {
+	if !cfg.FieldTimes {
+		return
+	}
+	if b.FieldTimes == nil {
+		b.FieldTimes = map[string]time.Time{}
+	}
+	b.FieldTimes[field] = at
+}
+
+// touchChangedFields records the modification of the tracked fields other
+// than the quantity, which adjustQuantity takes care of, that an update is
+// about to change in b. The caller must hold the write lock.
+func touchChangedFields(b *book, update book, at time.Time) {
+	changed := map[string]bool{
+		"title":  b.Title != update.Title,
+		"author": b.Author != update.Author,
+		"genre":  b.Genre != update.Genre,
+		"isbn":   b.ISBN != update.ISBN,
+	}
+	for field, differs := range changed {
+		if differs {
+			touchField(b, field, at)
+		}
+	}
+}
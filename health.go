@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// version is the version of the service, set at build time with
+// -ldflags "-X main.version=...".
+var version = "dev"
+
+// seedSource describes where the initial catalog came from: "builtin",
+// "file" or "url".
+var seedSource = "builtin"
+
+// seedCount is the number of books in the initial catalog.
+var seedCount = len(books)
+
+// health handles the public liveness check. It is kept minimal so it is
+// cheap to call and exposes nothing about the deployment.
+func health(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// healthDetails handles the admin-only diagnostic endpoint. On top of the
+// status it reports the version, the storage backend and persistence in
+// use, the state of the seed data, and the number of requests in flight
+// along with their latency, so operators can inspect a running instance
+// without shell access and autoscalers can tell when it is saturated.
+func healthDetails(c *gin.Context) {
+	booksMu.RLock()
+	count := 0
+	for _, b := range books {
+		if !b.isDeleted() {
+			count++
+		}
+	}
+	booksMu.RUnlock()
+
+	renderJSON(c, http.StatusOK, gin.H{
+		"status":     "ok",
+		"version":    version,
+		"go_version": runtime.Version(),
+		"uptime":     time.Since(startedAt).Round(time.Second).String(),
+		"storage":    "memory",
+		"persistence": gin.H{
+			"enabled":    cfg.PersistFile != "",
+			"compressed": cfg.PersistFile != "" && cfg.PersistGzip,
+			"loaded":     persistLoaded,
+		},
+		"seed": gin.H{
+			"source": seedSource,
+			"books":  seedCount,
+		},
+		"books":    count,
+		"requests": metrics.snapshot(),
+	})
+}
+
+// endpoint is a route listed by the root index.
+type endpoint struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+}
+
+// apiIndex is the response of the root path.
+type apiIndex struct {
+	Name      string     `json:"name"`
+	Version   string     `json:"version"`
+	Endpoints []endpoint `json:"endpoints"`
+}
+
+// rootIndex is the response of getRoot, built once by newAPIIndex after
+// every route is registered.
+var rootIndex apiIndex
+
+// adminRoutes lists the routes guarded by requireAdmin outside the /admin
+// group, keyed by method and route like routeMutates.
+var adminRoutes = map[string]bool{
+	"DELETE /books": true,
+}
+
+// newAPIIndex lists the public routes, sorted by path then method, so new
+// integrators can discover the API. The admin routes, in the /admin group
+// or in adminRoutes, and the routes of disabled features are left out.
+func newAPIIndex(routes gin.RoutesInfo) apiIndex {
+	index := apiIndex{Name: "goApi", Version: version, Endpoints: []endpoint{}}
+	for _, r := range routes {
+		route := strings.TrimPrefix(r.Path, cfg.BasePath)
+		key := r.Method + " " + route
+		if route == "/admin" || strings.HasPrefix(route, "/admin/") || adminRoutes[key] || !routeEnabled(key) {
+			continue
+		}
+		index.Endpoints = append(index.Endpoints, endpoint{Method: r.Method, Path: r.Path})
+	}
+	slices.SortFunc(index.Endpoints, func(a, b endpoint) int {
+		if c := strings.Compare(a.Path, b.Path); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Method, b.Method)
+	})
+	return index
+}
+
+// getRoot handles the HTTP request for the root path with an index of the
+// API: its version and the endpoints it serves.
+func getRoot(c *gin.Context) {
+	renderJSON(c, http.StatusOK, rootIndex)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// restockRequest is the payload of restockBook.
+type restockRequest struct {
+	Count int `json:"count" binding:"required,gt=0"`
+}
+
+// restockBook handles the HTTP request to add copies of an existing book.
+// It expects a JSON payload such as {"count": 3} with a positive count and
+// responds with the updated book.
+//
+// It responds with 400 Bad Request for malformed JSON, 422 Unprocessable
+// Entity for a count that isn't positive or when the new quantity would
+// exceed the configured maximum, and 404 Not Found when the book doesn't
+// exist.
+func restockBook(c *gin.Context) {
+	id, err := parseBookID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errInvalidBookID.body())
+		return
+	}
+	var req restockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(bindStatus(err), invalidRequest("Expected a JSON object with a positive count").body())
+		return
+	}
+
+	booksMu.Lock()
+	defer booksMu.Unlock()
+	book, err := getBookById(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errBookNotFound.body())
+		return
+	}
+	if err := checkQuantity(book.Quantity + req.Count); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errorBody(err))
+		return
+	}
+	now := time.Now().UTC()
+	adjustQuantity(book, req.Count, "restock", now)
+	syncCopies(book)
+	book.UpdatedAt = now
+	recordAudit(c, "restock", book.ID)
+	catalogChanged()
+	renderJSON(c, http.StatusOK, singleBook(c, *book))
+}
+
+// cloneRequest is the optional payload of cloneBook.
+type cloneRequest struct {
+	Quantity *int `json:"quantity"`
+}
+
+// cloneBook handles the HTTP request to create a new book from an existing
+// one, such as a second edition. The clone copies the title, author,
+// genre, tags and whether it can be checked out, gets a fresh ID and starts
+// with a quantity of 0, or the quantity given in an optional JSON payload
+// such as {"quantity": 2}. The ISBN is not copied since it identifies a
+// single edition.
+//
+// It responds with 404 Not Found when the source book doesn't exist, 400
+// Bad Request for malformed JSON, 422 Unprocessable Entity for an
+// out-of-bounds quantity, and 201 Created with the clone and its Location
+// otherwise.
+func cloneBook(c *gin.Context) {
+	id, err := parseBookID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errInvalidBookID.body())
+		return
+	}
+	var req cloneRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, errInvalidJSON.body())
+		return
+	}
+	quantity := 0
+	if req.Quantity != nil {
+		quantity = *req.Quantity
+	}
+	if err := checkQuantity(quantity); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errorBody(err))
+		return
+	}
+
+	booksMu.Lock()
+	defer booksMu.Unlock()
+	found, err := getBookById(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errBookNotFound.body())
+		return
+	}
+	// Copied as checkCapacity may move the books around.
+	source := *found
+	if full := checkCapacity(1); full != nil {
+		c.JSON(http.StatusInsufficientStorage, full.body())
+		return
+	}
+	clone := book{
+		ID:           nextBookID(),
+		Title:        source.Title,
+		Author:       source.Author,
+		Genre:        source.Genre,
+		Quantity:     quantity,
+		Checkoutable: source.Checkoutable,
+		Tags:         slices.Clone(source.Tags),
+	}
+	insertBook(&clone, time.Now().UTC(), "clone")
+	recordAudit(c, "clone", clone.ID)
+	catalogChanged()
+	c.Header("Location", cfg.BasePath+"/books/"+strconv.FormatInt(clone.ID, 10))
+	renderJSON(c, http.StatusCreated, singleBook(c, clone))
+}
+
+// setOrder handles the HTTP request to set the curated order of the
+// catalog. It expects a JSON array of book IDs, such as [3, 1], and gives
+// the listed books positions 1, 2, ... in that order. Books not listed lose
+// their position and fall to the end of `getBooks?sort=position`.
+//
+// It responds with 400 Bad Request when the payload is not an array of
+// integers or repeats an ID, 404 Not Found when an ID doesn't exist, without
+// changing any position, and 200 OK with the positioned books in order.
+func setOrder(c *gin.Context) {
+	var ids []int64
+	if err := c.ShouldBindJSON(&ids); err != nil {
+		c.JSON(http.StatusBadRequest, invalidRequest("Invalid JSON, expected an array of book IDs").body())
+		return
+	}
+
+	booksMu.Lock()
+	defer booksMu.Unlock()
+	positions := make(map[int64]int, len(ids))
+	for i, id := range ids {
+		if _, dup := positions[id]; dup {
+			c.JSON(http.StatusBadRequest, invalidRequest(fmt.Sprintf("Book %d is listed more than once", id)).body())
+			return
+		}
+		if _, err := getBookById(id); err != nil {
+			body := errBookNotFound.body()
+			body["id"] = id
+			c.JSON(http.StatusNotFound, body)
+			return
+		}
+		positions[id] = i + 1
+	}
+
+	now := time.Now().UTC()
+	ordered := make([]book, len(ids))
+	for i := range books {
+		b := &books[i]
+		if b.Position != positions[b.ID] {
+			b.Position = positions[b.ID]
+			b.UpdatedAt = now
+			recordAudit(c, "reorder", b.ID)
+		}
+		if b.Position > 0 {
+			ordered[b.Position-1] = *b
+		}
+	}
+	catalogChanged()
+	renderJSON(c, http.StatusOK, newBookResponses(ordered))
+}
+
+// swapRequest is the payload of swapPositions.
+type swapRequest struct {
+	A *int64 `json:"a"`
+	B *int64 `json:"b"`
+}
+
+// swapPositions handles the HTTP request to swap the positions of two
+// books in the curated order, e.g. {"a": 1, "b": 3}, the primitive behind
+// a drag-and-drop UI moving one book at a time. A book without a position
+// swaps it away, leaving the other at the end of `getBooks?sort=position`.
+//
+// It responds with 400 Bad Request when either ID is missing or both are
+// the same, 404 Not Found with the missing ID when either book doesn't
+// exist, without changing any position, and 200 OK with both books
+// otherwise.
+func swapPositions(c *gin.Context) {
+	var req swapRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errInvalidJSON.body())
+		return
+	}
+	if req.A == nil || req.B == nil {
+		c.JSON(http.StatusBadRequest, invalidRequest("Both a and b are required").body())
+		return
+	}
+	if *req.A == *req.B {
+		c.JSON(http.StatusBadRequest, invalidRequest("a and b must be different books").body())
+		return
+	}
+
+	booksMu.Lock()
+	defer booksMu.Unlock()
+	var pair [2]*book
+	for i, id := range []int64{*req.A, *req.B} {
+		b, err := getBookById(id)
+		if err != nil {
+			body := errBookNotFound.body()
+			body["id"] = id
+			c.JSON(http.StatusNotFound, body)
+			return
+		}
+		pair[i] = b
+	}
+
+	a, b := pair[0], pair[1]
+	if a.Position != b.Position {
+		now := time.Now().UTC()
+		a.Position, b.Position = b.Position, a.Position
+		a.UpdatedAt, b.UpdatedAt = now, now
+		recordAudit(c, "reorder", a.ID)
+		recordAudit(c, "reorder", b.ID)
+		catalogChanged()
+	}
+	renderJSON(c, http.StatusOK, newBookResponses([]book{*a, *b}))
+}
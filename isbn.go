@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// normalizeISBN strips the hyphens and spaces commonly used to group the
+// digits of an ISBN and upper-cases a trailing "x" check digit.
+func normalizeISBN(s string) string {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	return strings.NewReplacer("-", "", " ", "").Replace(s)
+}
+
+// validISBN reports whether the normalized s is a structurally valid
+// ISBN-10 or ISBN-13, including its check digit.
+func validISBN(s string) bool {
+	switch len(s) {
+	case 10:
+		sum := 0
+		for i, r := range s {
+			var d int
+			switch {
+			case r >= '0' && r <= '9':
+				d = int(r - '0')
+			case r == 'X' && i == 9:
+				d = 10
+			default:
+				return false
+			}
+			sum += d * (10 - i)
+		}
+		return sum%11 == 0
+	case 13:
+		sum := 0
+		for i, r := range s {
+			if r < '0' || r > '9' {
+				return false
+			}
+			d := int(r - '0')
+			if i%2 == 1 {
+				d *= 3
+			}
+			sum += d
+		}
+		return sum%10 == 0
+	}
+	return false
+}
+
+// getBookByISBN looks up a live book by its normalized ISBN and returns the
+// book if found. The caller must hold booksMu for as long as it uses the
+// returned pointer.
+func getBookByISBN(isbn string) (*book, bool) {
+	if isbn == "" {
+		return nil, false
+	}
+	for i := range books {
+		if books[i].ISBN == isbn && !books[i].isDeleted() {
+			return &books[i], true
+		}
+	}
+	return nil, false
+}
+
+// isbnValidation is the result of validateISBN.
+type isbnValidation struct {
+	ISBN       string `json:"isbn"`
+	Valid      bool   `json:"valid"`
+	Normalized string `json:"normalized"`
+}
+
+// validateISBN handles the HTTP request to check an ISBN, given in the
+// `isbn` query parameter, without creating a book. It applies the same
+// normalization and validation as createBooks, so clients building a
+// book-entry form can check the field as it is typed. It responds with 400
+// Bad Request when the parameter is missing, and 200 OK with whether the
+// ISBN is valid and its normalized form otherwise, even when it is invalid.
+func validateISBN(c *gin.Context) {
+	isbn, ok := c.GetQuery("isbn")
+	if !ok || strings.TrimSpace(isbn) == "" {
+		c.JSON(http.StatusBadRequest, invalidRequest("Missing isbn query parameter").body())
+		return
+	}
+	normalized := normalizeISBN(isbn)
+	renderJSON(c, http.StatusOK, isbnValidation{ISBN: isbn, Valid: validISBN(normalized), Normalized: normalized})
+}
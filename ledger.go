@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxLedgerEntries bounds the ledger of each book. Beyond it the oldest
+// entries are folded into a single "carried forward" entry, so the deltas
+// still add up to the current quantity.
+const maxLedgerEntries = 500
+
+// ledgerEntry records one change to the quantity of a book.
+type ledgerEntry struct {
+	At    time.Time `json:"at"`
+	Delta int       `json:"delta"`
+	// Balance is the quantity after the change.
+	Balance int `json:"balance"`
+	// Reason names what changed the quantity, such as "checkout",
+	// "return", "restock" or "damaged".
+	Reason string `json:"reason"`
+}
+
+// adjustQuantity changes the quantity of b by delta for the given reason
+// and records the change in its ledger. Every change to the quantity of a
+// book in the catalog goes through it, so the ledger reconciles with the
+// quantity. The caller must hold the write lock.
+func adjustQuantity(b *book, delta int, reason string, at time.Time) {
+	b.Quantity += delta
+	touchField(b, "quantity", at)
+	appendLedger(b, ledgerEntry{At: at, Delta: delta, Balance: b.Quantity, Reason: reason})
+}
+
+// openLedger starts the ledger of b with its current quantity as the
+// opening balance. The caller must hold the write lock.
+func openLedger(b *book, reason string, at time.Time) {
+	b.Ledger = nil
+	appendLedger(b, ledgerEntry{At: at, Delta: b.Quantity, Balance: b.Quantity, Reason: reason})
+}
+
+// appendLedger adds e to the ledger of b, folding the oldest entries when
+// it is full.
+func appendLedger(b *book, e ledgerEntry) {
+	b.Ledger = append(b.Ledger, e)
+	if excess := len(b.Ledger) - maxLedgerEntries; excess > 0 {
+		folded := b.Ledger[excess]
+		folded.Delta = folded.Balance
+		folded.Reason = "carried forward"
+		b.Ledger = append([]ledgerEntry{folded}, b.Ledger[excess+1:]...)
+	}
+}
+
+// openAllLedgers opens the ledger of every book loaded from a seed or
+// saved catalog that doesn't have one yet.
+func openAllLedgers() {
+	booksMu.Lock()
+	defer booksMu.Unlock()
+	now := time.Now().UTC()
+	for i := range books {
+		if len(books[i].Ledger) == 0 {
+			openLedger(&books[i], "opening balance", now)
+		}
+	}
+}
+
+// getBookLedger handles the HTTP request for the inventory ledger of a
+// book: every change to its quantity, oldest first, with the balance after
+// each. The deltas add up to the current quantity. It responds with 404 Not
+// Found when the book doesn't exist.
+func getBookLedger(c *gin.Context) {
+	id, err := parseBookID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errInvalidBookID.body())
+		return
+	}
+
+	booksMu.RLock()
+	defer booksMu.RUnlock()
+	book, err := getBookById(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errBookNotFound.body())
+		return
+	}
+	renderJSON(c, http.StatusOK, append([]ledgerEntry{}, book.Ledger...))
+}
+
+// lastCheckout returns the time of the most recent checkout in the ledger
+// of b, and false when there is none.
+func lastCheckout(b book) (time.Time, bool) {
+	for i := len(b.Ledger) - 1; i >= 0; i-- {
+		if b.Ledger[i].Reason == "checkout" {
+			return b.Ledger[i].At, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// getNeverCheckedOut handles the HTTP request for the books that have never
+// been checked out, the candidates for weeding the collection. With a
+// `since` query parameter, an RFC 3339 time or a YYYY-MM-DD date taken as
+// midnight UTC, it returns the books not checked out since then instead.
+//
+// Checkouts are read from the ledgers, so they survive restarts when the
+// catalog is persisted. Checkouts folded out of a full ledger are no longer
+// counted, but such a book has had hundreds of recent changes to go by.
+func getNeverCheckedOut(c *gin.Context) {
+	var since time.Time
+	if s := c.Query("since"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t, err = time.Parse(time.DateOnly, s)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, invalidRequest("Invalid since, expected RFC 3339 or YYYY-MM-DD").body())
+			return
+		}
+		since = t
+	}
+
+	unused := []book{}
+	booksMu.RLock()
+	for _, b := range books {
+		if b.isDeleted() {
+			continue
+		}
+		if at, ok := lastCheckout(b); !ok || at.Before(since) {
+			unused = append(unused, b)
+		}
+	}
+	booksMu.RUnlock()
+	renderJSON(c, http.StatusOK, newBookResponses(unused))
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"maps"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// linksProfile is the Accept profile with which a client asks for
+// hypermedia links in single-book responses, e.g.
+// `Accept: application/json; profile="links"`.
+const linksProfile = "links"
+
+// link is a hypermedia link to a related resource.
+type link struct {
+	Href string `json:"href"`
+}
+
+// detailedBook is a book with the links a hypermedia-aware client can
+// follow from it and the details it asked for.
+type detailedBook struct {
+	bookResponse
+	Links map[string]link `json:"_links,omitempty"`
+	Meta  *bookMeta       `json:"_meta,omitempty"`
+}
+
+// singleBook returns the representation of b for a single-book response:
+// the plain book, with its _links when BookLinks is configured or the
+// client asked for the links profile, and with the modification time of
+// each field under _meta.field_times when FieldTimes is configured and the
+// client asked for the field-times profile. The links are self, copies and
+// loans, plus checkout while a copy can be checked out.
+func singleBook(c *gin.Context, b book) any {
+	resp := detailedBook{bookResponse: newBookResponse(b)}
+	if cfg.BookLinks || acceptsProfile(c, linksProfile) {
+		resp.Links = bookLinks(c, b)
+	}
+	if cfg.FieldTimes && acceptsProfile(c, fieldTimesProfile) {
+		resp.Meta = &bookMeta{FieldTimes: maps.Clone(b.FieldTimes)}
+		if resp.Meta.FieldTimes == nil {
+			resp.Meta.FieldTimes = map[string]time.Time{}
+		}
+	}
+	return resp
+}
+
+// bookLinks returns the links of b for a single-book response.
+func bookLinks(c *gin.Context, b book) map[string]link {
+	base := baseURL(c) + cfg.BasePath
+	self := base + "/books/" + strconv.FormatInt(b.ID, 10)
+	links := map[string]link{
+		"self":   {Href: self},
+		"copies": {Href: self + "/copies"},
+		"loans":  {Href: self + "/loans"},
+	}
+	if b.Checkoutable && b.Quantity > 0 {
+		links["checkout"] = link{Href: base + "/checkout?id=" + strconv.FormatInt(b.ID, 10)}
+	}
+	return links
+}
+
+// baseURL returns the scheme and host the client used to reach the
+// service, honoring the X-Forwarded-Proto and X-Forwarded-Host headers set
+// by reverse proxies, so links stay correct behind one. It doesn't include
+// the base path.
+func baseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	host := c.Request.Host
+	if fwd := c.GetHeader("X-Forwarded-Host"); fwd != "" {
+		host = fwd
+	}
+	return scheme + "://" + host
+}
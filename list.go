@@ -0,0 +1,374 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// listQuery holds the filtering, sorting and pagination options of a
+// request listing books. getBooks applies them in a fixed order: filter,
+// then sort, then paginate.
+type listQuery struct {
+	Author string // case-insensitive substring of the author
+	Genre  string // case-insensitive exact genre
+	// Available, when set, keeps only books in stock (true) or out of
+	// stock (false).
+	Available *bool
+	// IDFrom and IDTo, when set, keep only books whose ID is within the
+	// inclusive range.
+	IDFrom *int64
+	IDTo   *int64
+	// Since, when set, keeps only books changed after it, including the
+	// books deleted since, as tombstones, for incremental sync.
+	Since *time.Time
+	// Sort is the raw `sort` parameter, empty to keep catalog order, and
+	// SortFields the keys it lists, compared in turn.
+	Sort       string
+	SortFields []sortField
+	Desc       bool // default direction of the sort fields, from `order`
+	Offset     int  // number of books to skip after sorting
+	Limit      int  // maximum number of books to return, 0 means no limit
+}
+
+// sortKeys maps the accepted values of the `sort` query parameter to the
+// comparison used for them.
+var sortKeys = map[string]func(a, b book) int{
+	"id":         func(a, b book) int { return cmp.Compare(a.ID, b.ID) },
+	"title":      func(a, b book) int { return cmp.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title)) },
+	"author":     func(a, b book) int { return cmp.Compare(strings.ToLower(a.Author), strings.ToLower(b.Author)) },
+	"genre":      func(a, b book) int { return cmp.Compare(strings.ToLower(a.Genre), strings.ToLower(b.Genre)) },
+	"quantity":   func(a, b book) int { return cmp.Compare(a.Quantity, b.Quantity) },
+	"created_at": func(a, b book) int { return a.CreatedAt.Compare(b.CreatedAt) },
+	"position":   func(a, b book) int { return cmp.Compare(positionKey(a), positionKey(b)) },
+}
+
+// sortField is one key of a multi-level sort and its direction.
+type sortField struct {
+	Key  string // one of the keys in sortKeys
+	Desc bool
+}
+
+// parseSort parses a `sort` parameter listing comma-separated keys, each
+// optionally followed by ":asc" or ":desc", such as "author:asc,title:desc".
+// Keys without a direction use the default one.
+func parseSort(s string, defaultDesc bool) ([]sortField, error) {
+	var fields []sortField
+	for _, part := range strings.Split(s, ",") {
+		key, dir, hasDir := strings.Cut(strings.TrimSpace(part), ":")
+		if _, ok := sortKeys[key]; !ok {
+			return nil, errors.New("Invalid sort key")
+		}
+		f := sortField{Key: key, Desc: defaultDesc}
+		if hasDir {
+			switch dir {
+			case "asc":
+				f.Desc = false
+			case "desc":
+				f.Desc = true
+			default:
+				return nil, errors.New("Invalid sort direction, must be asc or desc")
+			}
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// positionKey returns the position of b for sorting, with books without a
+// position placed after all the others.
+func positionKey(b book) int {
+	if b.Position == 0 {
+		return math.MaxInt
+	}
+	return b.Position
+}
+
+// parseListQuery reads the list options from the query string. It returns
+// an error describing the first invalid parameter.
+func parseListQuery(c *gin.Context) (listQuery, error) {
+	q := listQuery{
+		Author: strings.ToLower(strings.TrimSpace(c.Query("author"))),
+		Genre:  strings.ToLower(strings.TrimSpace(c.Query("genre"))),
+		Sort:   c.Query("sort"),
+	}
+
+	if availableStr, ok := c.GetQuery("available"); ok {
+		available, err := strconv.ParseBool(availableStr)
+		if err != nil {
+			return q, errors.New("Invalid available, must be true or false")
+		}
+		q.Available = &available
+	}
+	if s, ok := c.GetQuery("id_from"); ok {
+		id, err := parseBookID(s)
+		if err != nil {
+			return q, errors.New("Invalid id_from")
+		}
+		q.IDFrom = &id
+	}
+	if s, ok := c.GetQuery("id_to"); ok {
+		id, err := parseBookID(s)
+		if err != nil {
+			return q, errors.New("Invalid id_to")
+		}
+		q.IDTo = &id
+	}
+	if q.IDFrom != nil && q.IDTo != nil && *q.IDFrom > *q.IDTo {
+		return q, errors.New("id_from must not be greater than id_to")
+	}
+	if s, ok := c.GetQuery("since"); ok {
+		since, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return q, errors.New("Invalid since, expected an RFC 3339 time")
+		}
+		q.Since = &since
+	}
+	switch c.DefaultQuery("order", "asc") {
+	case "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return q, errors.New("Invalid order, must be asc or desc")
+	}
+	if q.Sort != "" {
+		fields, err := parseSort(q.Sort, q.Desc)
+		if err != nil {
+			return q, err
+		}
+		q.SortFields = fields
+	}
+
+	if offsetStr, ok := c.GetQuery("offset"); ok {
+		n, err := strconv.Atoi(offsetStr)
+		if err != nil || n < 0 {
+			return q, errors.New("Invalid offset")
+		}
+		q.Offset = n
+	}
+	if limitStr, ok := c.GetQuery("limit"); ok {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n <= 0 {
+			return q, errors.New("Invalid limit")
+		}
+		q.Limit = n
+	}
+	return q, nil
+}
+
+// matches reports whether b matches the filters of q. Deleted books only
+// match as tombstones, when q asks for the changes since a time.
+func (q listQuery) matches(b book) bool {
+	if b.isDeleted() && q.Since == nil {
+		return false
+	}
+	if q.Since != nil && !b.UpdatedAt.After(*q.Since) {
+		return false
+	}
+	if q.Author != "" && !strings.Contains(strings.ToLower(b.Author), q.Author) {
+		return false
+	}
+	if q.Genre != "" && strings.ToLower(b.Genre) != q.Genre {
+		return false
+	}
+	if q.Available != nil && (b.Quantity > 0) != *q.Available {
+		return false
+	}
+	if q.IDFrom != nil && b.ID < *q.IDFrom {
+		return false
+	}
+	if q.IDTo != nil && b.ID > *q.IDTo {
+		return false
+	}
+	return true
+}
+
+// filterBooks returns a new slice with the books matching the filters of
+// q. The input slice is never modified. It stops early with the context's
+// error when ctx is done, such as when the client has gone away.
+func filterBooks(ctx context.Context, list []book, q listQuery) ([]book, error) {
+	filtered := []book{}
+	for i, b := range list {
+		if i%cancelCheckEvery == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if q.matches(b) {
+			filtered = append(filtered, b)
+		}
+	}
+	return filtered, nil
+}
+
+// sortBooks sorts list in place by the sort fields of q, comparing by the
+// next field when the previous ones are equal. Books comparing equal on
+// every field are ordered by ascending ID, so the order is the same on
+// every request and pages never overlap. Callers pass a filtered copy, so
+// the catalog order is never changed.
+func sortBooks(list []book, q listQuery) {
+	if len(q.SortFields) == 0 {
+		return
+	}
+	slices.SortStableFunc(list, func(a, b book) int {
+		for _, f := range q.SortFields {
+			n := sortKeys[f.Key](a, b)
+			if f.Desc {
+				n = -n
+			}
+			if n != 0 {
+				return n
+			}
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+}
+
+// paginate returns the window of list selected by the offset and limit of q.
+func paginate(list []book, q listQuery) []book {
+	if q.Offset >= len(list) {
+		return []book{}
+	}
+	list = list[q.Offset:]
+	if q.Limit > 0 && len(list) > q.Limit {
+		list = list[:q.Limit]
+	}
+	return list
+}
+
+// paginationLinks returns the value of the Link header of a page of a list
+// of total books starting at offset, with limit books per page: the URLs of
+// the first, previous, next and last pages, built from the request URL with
+// the offset adjusted. The previous and next links are omitted on the first
+// and last pages. It returns "" when the list isn't paginated.
+func paginationLinks(c *gin.Context, offset, limit, total int) string {
+	if limit <= 0 {
+		return ""
+	}
+	link := func(rel string, offset int) string {
+		query := c.Request.URL.Query()
+		query.Set("offset", strconv.Itoa(offset))
+		query.Set("limit", strconv.Itoa(limit))
+		return "<" + baseURL(c) + c.Request.URL.Path + "?" + query.Encode() + `>; rel="` + rel + `"`
+	}
+	links := []string{link("first", 0)}
+	if offset > 0 {
+		links = append(links, link("prev", max(offset-limit, 0)))
+	}
+	if offset+limit < total {
+		links = append(links, link("next", offset+limit))
+	}
+	links = append(links, link("last", max(total-1, 0)/limit*limit))
+	return strings.Join(links, ", ")
+}
+
+// itemsRange is a range of list items requested with a Range header.
+type itemsRange struct {
+	first int
+	last  int // inclusive, -1 when the range runs to the end of the list
+}
+
+// count returns the number of items in r, 0 when it runs to the end of the
+// list, like the limit of a listQuery.
+func (r itemsRange) count() int {
+	if r.last < 0 {
+		return 0
+	}
+	return r.last - r.first + 1
+}
+
+// parseItemsRange parses a Range header such as "items=0-19" or
+// "items=20-". It reports false, without an error, for an empty header or
+// one in another unit, which are to be ignored. Multiple ranges aren't
+// supported.
+func parseItemsRange(h string) (itemsRange, bool, error) {
+	spec, ok := strings.CutPrefix(h, "items=")
+	if !ok {
+		return itemsRange{}, false, nil
+	}
+	invalid := errors.New("Invalid range, expected e.g. items=0-19")
+	firstStr, lastStr, ok := strings.Cut(spec, "-")
+	first, err := strconv.Atoi(firstStr)
+	if !ok || err != nil || first < 0 {
+		return itemsRange{}, false, invalid
+	}
+	r := itemsRange{first: first, last: -1}
+	if lastStr != "" {
+		if r.last, err = strconv.Atoi(lastStr); err != nil || r.last < first {
+			return itemsRange{}, false, invalid
+		}
+	}
+	return r, true, nil
+}
+
+// envelopeProfile is the Accept profile with which a client asks for a
+// wrapped list response, e.g. `Accept: application/json; profile="envelope"`.
+const envelopeProfile = "envelope"
+
+// listEnvelope is the wrapped form of a list response.
+type listEnvelope struct {
+	Data []bookResponse `json:"data"`
+	Meta listMeta       `json:"meta"`
+}
+
+// listMeta describes the page of a list response and how it was selected.
+type listMeta struct {
+	Total   int               `json:"total"`
+	Count   int               `json:"count"`
+	Offset  int               `json:"offset"`
+	Limit   int               `json:"limit,omitempty"`
+	Sort    string            `json:"sort,omitempty"`
+	Order   string            `json:"order,omitempty"`
+	Filters map[string]string `json:"filters,omitempty"`
+}
+
+// wantsEnvelope reports whether the list response should be wrapped, either
+// because ListEnvelope is configured or because the client asked for the
+// envelope profile in its Accept header. The bare array stays the default.
+func wantsEnvelope(c *gin.Context) bool {
+	return cfg.ListEnvelope || acceptsProfile(c, envelopeProfile)
+}
+
+// newListEnvelope wraps page, selected by q out of total matching books.
+func newListEnvelope(page []bookResponse, total int, q listQuery) listEnvelope {
+	meta := listMeta{
+		Total:  total,
+		Count:  len(page),
+		Offset: q.Offset,
+		Limit:  q.Limit,
+		Sort:   q.Sort,
+	}
+	if q.Sort != "" {
+		meta.Order = "asc"
+		if q.Desc {
+			meta.Order = "desc"
+		}
+	}
+	if q.Author != "" || q.Genre != "" || q.Available != nil || q.IDFrom != nil || q.IDTo != nil || q.Since != nil {
+		meta.Filters = map[string]string{}
+		if q.Author != "" {
+			meta.Filters["author"] = q.Author
+		}
+		if q.Genre != "" {
+			meta.Filters["genre"] = q.Genre
+		}
+		if q.Available != nil {
+			meta.Filters["available"] = strconv.FormatBool(*q.Available)
+		}
+		if q.IDFrom != nil {
+			meta.Filters["id_from"] = strconv.FormatInt(*q.IDFrom, 10)
+		}
+		if q.IDTo != nil {
+			meta.Filters["id_to"] = strconv.FormatInt(*q.IDTo, 10)
+		}
+		if q.Since != nil {
+			meta.Filters["since"] = q.Since.Format(time.RFC3339Nano)
+		}
+	}
+	return listEnvelope{Data: page, Meta: meta}
+}
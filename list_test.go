@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestPaginationLinks(t *testing.T) {
+	r := newTestRouter(t, nil)
+	seedCatalog(7, 1)
+	page := func(offset string) string {
+		return "http://example.com/books?limit=3&offset=" + offset + "&sort=title"
+	}
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"sort=title&limit=3", `<` + page("0") + `>; rel="first", <` + page("3") + `>; rel="next", <` + page("6") + `>; rel="last"`},
+		{"sort=title&limit=3&offset=3", `<` + page("0") + `>; rel="first", <` + page("0") + `>; rel="prev", <` + page("6") + `>; rel="next", <` + page("6") + `>; rel="last"`},
+		{"sort=title&limit=3&offset=6", `<` + page("0") + `>; rel="first", <` + page("3") + `>; rel="prev", <` + page("6") + `>; rel="last"`},
+		// An offset off the page grid steps back by a page, down to 0.
+		{"sort=title&limit=3&offset=2", `<` + page("0") + `>; rel="first", <` + page("0") + `>; rel="prev", <` + page("5") + `>; rel="next", <` + page("6") + `>; rel="last"`},
+		// Without a limit the list isn't paginated.
+		{"sort=title&offset=3", ""},
+	}
+	for _, tt := range tests {
+		w := do(r, http.MethodGet, "/books?"+tt.query, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /books?%s: status %d, body %s", tt.query, w.Code, w.Body)
+		}
+		if got := w.Header().Get("Link"); got != tt.want {
+			t.Errorf("GET /books?%s: Link\n%s\nwant\n%s", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestSortTieBreak(t *testing.T) {
+	r := newTestRouter(t, nil)
+	// Created out of ID order, with the author and quantity of book 2.
+	for _, id := range []string{"20", "15", "18"} {
+		mustCreate(t, r, `{"id": `+id+`, "title": "Twin Title", "author": "Katherine Cox-Buday", "quantity": 5}`)
+	}
+
+	tests := []struct {
+		query string
+		want  []int64
+	}{
+		{"sort=author", []int64{1, 3, 2, 15, 18, 20}},
+		// Ties stay in ascending ID order whatever the direction.
+		{"sort=author&order=desc", []int64{2, 15, 18, 20, 3, 1}},
+		{"sort=quantity", []int64{1, 2, 15, 18, 20, 3}},
+		{"sort=quantity&limit=2&offset=0", []int64{1, 2}},
+		{"sort=quantity&limit=2&offset=2", []int64{15, 18}},
+		{"sort=quantity&limit=2&offset=4", []int64{20, 3}},
+		{"sort=quantity,author", []int64{1, 2, 15, 18, 20, 3}},
+	}
+	for _, tt := range tests {
+		// The order is the same on every request.
+		for range 3 {
+			var got []book
+			decode(t, do(r, http.MethodGet, "/books?"+tt.query, ""), &got)
+			if ids := bookIDs(got); !slices.Equal(ids, tt.want) {
+				t.Fatalf("GET /books?%s = %v, want %v", tt.query, ids, tt.want)
+			}
+		}
+	}
+
+	var results []book
+	decode(t, do(r, http.MethodGet, "/books/search?q=twin+title", ""), &results)
+	if ids := bookIDs(results); !slices.Equal(ids, []int64{15, 18, 20}) {
+		t.Errorf("search for books with the same score = %v, want [15 18 20]", ids)
+	}
+}
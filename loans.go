@@ -0,0 +1,493 @@
+package main
+
+import (
+	"cmp"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxBorrowerLength is the longest borrower name accepted, in bytes.
+const maxBorrowerLength = 100
+
+// loan records one copy of a book checked out by a borrower. A loan is
+// active until it is returned.
+type loan struct {
+	ID           int        `json:"id"`
+	BookID       int64      `json:"book_id"`
+	CopyID       int        `json:"copy_id,omitempty"`
+	Borrower     string     `json:"borrower"`
+	CheckedOutAt time.Time  `json:"checked_out_at"`
+	DueAt        time.Time  `json:"due_date"`
+	ReturnedAt   *time.Time `json:"returned_at,omitempty"`
+}
+
+// overdueLoan is an active loan past its due date, as reported by
+// getOverdueLoans.
+type overdueLoan struct {
+	loan
+	DaysOverdue int `json:"days_overdue"`
+}
+
+// isActive reports whether the loan has not been returned yet.
+func (l loan) isActive() bool {
+	return l.ReturnedAt == nil
+}
+
+// loans holds every loan, active or returned, in the order they were
+// opened. Like books, it is guarded by booksMu so a checkout updates the
+// quantity and the loans together. Loans are saved with the catalog when
+// persistence is enabled.
+var loans []loan
+
+// nextLoanID is the ID given to the next loan.
+var nextLoanID = 1
+
+// openLoan records a new active loan of the given copy, due at the given
+// time, and returns it. The caller must hold the write lock.
+func openLoan(bookID int64, copyID int, borrower string, now, due time.Time) loan {
+	l := loan{ID: nextLoanID, BookID: bookID, CopyID: copyID, Borrower: borrower, CheckedOutAt: now, DueAt: due}
+	nextLoanID++
+	loans = append(loans, l)
+	return l
+}
+
+// recentCheckouts holds the time of the last checkout of each book by each
+// client, keyed by client IP and book ID, while it is within the configured
+// CheckoutCooldown. Like loans, it is guarded by booksMu.
+var recentCheckouts = map[string]time.Time{}
+
+// checkoutCooldown returns how long the client of c must still wait before
+// checking out the book with the given ID again, or 0 when it may. It is
+// always 0 while no cooldown is configured and for admins, who check out on
+// behalf of many borrowers from one front desk. The caller must hold
+// booksMu.
+func checkoutCooldown(c *gin.Context, bookID int64, now time.Time) time.Duration {
+	if cfg.CheckoutCooldown <= 0 || hasAdminKey(c, cfg.AdminKey) {
+		return 0
+	}
+	last, ok := recentCheckouts[c.ClientIP()+"|"+strconv.FormatInt(bookID, 10)]
+	if !ok {
+		return 0
+	}
+	return max(last.Add(cfg.CheckoutCooldown).Sub(now), 0)
+}
+
+// recordCheckout starts the cooldown of the book with the given ID for the
+// client of c, forgetting the cooldowns that have run out. The caller must
+// hold the write lock.
+func recordCheckout(c *gin.Context, bookID int64, now time.Time) {
+	if cfg.CheckoutCooldown <= 0 {
+		return
+	}
+	for key, last := range recentCheckouts {
+		if now.Sub(last) >= cfg.CheckoutCooldown {
+			delete(recentCheckouts, key)
+		}
+	}
+	recentCheckouts[c.ClientIP()+"|"+strconv.FormatInt(bookID, 10)] = now
+}
+
+// activeLoanCount returns the number of copies the borrower has checked
+// out, comparing names case-insensitively. The caller must hold booksMu.
+func activeLoanCount(borrower string) int {
+	n := 0
+	for _, l := range loans {
+		if l.isActive() && strings.EqualFold(l.Borrower, borrower) {
+			n++
+		}
+	}
+	return n
+}
+
+// receipt is the printable record of a checkout returned by checkoutBook
+// on request, for front-desk workflows.
+type receipt struct {
+	// ID is a random identifier printed on the receipt.
+	ID       string       `json:"receipt_id"`
+	IssuedAt time.Time    `json:"issued_at"`
+	LoanID   int          `json:"loan_id"`
+	CopyID   int          `json:"copy_id"`
+	Borrower string       `json:"borrower,omitempty"`
+	DueAt    time.Time    `json:"due_date"`
+	Book     bookResponse `json:"book"`
+}
+
+// newReceipt returns the receipt of the loan l of book b.
+func newReceipt(l loan, b book) receipt {
+	var id [8]byte
+	rand.Read(id[:])
+	return receipt{
+		ID:       "R-" + strings.ToUpper(hex.EncodeToString(id[:])),
+		IssuedAt: l.CheckedOutAt,
+		LoanID:   l.ID,
+		CopyID:   l.CopyID,
+		Borrower: l.Borrower,
+		DueAt:    l.DueAt,
+		Book:     newBookResponse(b),
+	}
+}
+
+// getLoanById returns the loan with the given ID. The caller must hold
+// booksMu for as long as it uses the returned pointer.
+func getLoanById(id int) (*loan, bool) {
+	for i := range loans {
+		if loans[i].ID == id {
+			return &loans[i], true
+		}
+	}
+	return nil, false
+}
+
+// parseBorrower validates a borrower name and returns it trimmed.
+func parseBorrower(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	return s, len(s) <= maxBorrowerLength
+}
+
+// parseDueDate returns the due date of a loan opened at now. An empty s
+// means the configured loan period from now. Otherwise s must be an RFC 3339
+// time or a YYYY-MM-DD date, taken as midnight UTC, in the future.
+func parseDueDate(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return now.Add(cfg.LoanPeriod), nil
+	}
+	due, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		due, err = time.Parse(time.DateOnly, s)
+	}
+	if err != nil {
+		return time.Time{}, errors.New("Invalid due_date, expected RFC 3339 or YYYY-MM-DD")
+	}
+	if !due.After(now) {
+		return time.Time{}, errors.New("due_date must be in the future")
+	}
+	return due.UTC(), nil
+}
+
+// getOverdueLoans handles the HTTP request for every active loan past its
+// due date, across all books, most overdue first, then by loan ID. Each
+// loan includes the number of whole days it is overdue.
+func getOverdueLoans(c *gin.Context) {
+	now := time.Now().UTC()
+
+	booksMu.RLock()
+	overdue := []overdueLoan{}
+	for _, l := range loans {
+		if l.isActive() && now.After(l.DueAt) {
+			days := int(now.Sub(l.DueAt) / (24 * time.Hour))
+			overdue = append(overdue, overdueLoan{loan: l, DaysOverdue: days})
+		}
+	}
+	booksMu.RUnlock()
+
+	slices.SortStableFunc(overdue, func(a, b overdueLoan) int {
+		if n := a.DueAt.Compare(b.DueAt); n != 0 {
+			return n
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+	renderJSON(c, http.StatusOK, overdue)
+}
+
+// getBookLoans handles the HTTP request for the active loans of a book.
+// It responds with 404 Not Found when the book doesn't exist and an empty
+// array when no copy is checked out.
+func getBookLoans(c *gin.Context) {
+	id, err := parseBookID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errInvalidBookID.body())
+		return
+	}
+
+	booksMu.RLock()
+	defer booksMu.RUnlock()
+	if _, err := getBookById(id); err != nil {
+		c.JSON(http.StatusNotFound, errBookNotFound.body())
+		return
+	}
+	active := []loan{}
+	for _, l := range loans {
+		if l.BookID == id && l.isActive() {
+			active = append(active, l)
+		}
+	}
+	renderJSON(c, http.StatusOK, active)
+}
+
+// returnLoan handles the HTTP request to return the copy of a loan. The
+// loan is closed, the copy is available again and the book's quantity goes
+// back up by one.
+//
+// It responds with 404 Not Found when the loan doesn't exist, 409 Conflict
+// when it was already returned, 422 Unprocessable Entity when the returned
+// copy would push the quantity past the configured maximum, and 200 OK with
+// the closed loan otherwise.
+func returnLoan(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errInvalidLoanID.body())
+		return
+	}
+
+	booksMu.Lock()
+	defer booksMu.Unlock()
+	l, ok := getLoanById(id)
+	if !ok {
+		c.JSON(http.StatusNotFound, errLoanNotFound.body())
+		return
+	}
+	if !l.isActive() {
+		c.JSON(http.StatusConflict, errLoanReturned.body())
+		return
+	}
+
+	now := time.Now().UTC()
+	if book, err := getBookById(l.BookID); err == nil {
+		if err := checkQuantity(book.Quantity + 1); err != nil {
+			c.JSON(validationStatus(err), errorBody(err))
+			return
+		}
+	}
+	closeLoan(l, now)
+	recordAudit(c, "return", l.BookID)
+	catalogChanged()
+	renderJSON(c, http.StatusOK, *l)
+}
+
+// transferRequest is the payload of transferLoan.
+type transferRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Loan is the ID of the loan transferred. The oldest active loan of the
+	// book by From is used when it is omitted.
+	Loan int `json:"loan"`
+}
+
+// transferLoan handles the HTTP request to move an active loan of a book
+// from its borrower to another, e.g. {"from": "Ada", "to": "Grace"}, as in
+// an interlibrary or peer transfer. The copy stays checked out, so the
+// quantity doesn't change, and the audit log records both borrowers.
+// Borrowers are matched case-insensitively.
+//
+// It responds with 400 Bad Request for malformed JSON, 422 Unprocessable
+// Entity when from or to is missing, too long or the same borrower, 404 Not
+// Found when the book or the loan doesn't exist, 409 Conflict when the book,
+// or the loan, isn't checked out by from, 403 Forbidden when to would
+// exceed MaxLoansPerBorrower, which admins may, and 200 OK with the loan
+// otherwise.
+func transferLoan(c *gin.Context) {
+	id, err := parseBookID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errInvalidBookID.body())
+		return
+	}
+	var req transferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errInvalidJSON.body())
+		return
+	}
+	from, okFrom := parseBorrower(req.From)
+	to, okTo := parseBorrower(req.To)
+	switch {
+	case from == "" || to == "":
+		c.JSON(http.StatusUnprocessableEntity, invalidRequest("Both from and to are required").body())
+		return
+	case !okFrom || !okTo:
+		c.JSON(http.StatusUnprocessableEntity, invalidRequest("Borrower name too long").body())
+		return
+	case strings.EqualFold(from, to):
+		c.JSON(http.StatusUnprocessableEntity, invalidRequest("from and to must be different borrowers").body())
+		return
+	}
+
+	booksMu.Lock()
+	defer booksMu.Unlock()
+	if _, err := getBookById(id); err != nil {
+		c.JSON(http.StatusNotFound, errBookNotFound.body())
+		return
+	}
+	var l *loan
+	if req.Loan != 0 {
+		found, ok := getLoanById(req.Loan)
+		if !ok || found.BookID != id {
+			c.JSON(http.StatusNotFound, errLoanNotFound.body())
+			return
+		}
+		if !found.isActive() || !strings.EqualFold(found.Borrower, from) {
+			c.JSON(http.StatusConflict, (&apiError{Code: "wrong_borrower", Message: "Loan is not checked out by " + from}).body())
+			return
+		}
+		l = found
+	} else {
+		for _, active := range activeLoans(id) {
+			if strings.EqualFold(active.Borrower, from) {
+				l = active
+				break
+			}
+		}
+		if l == nil {
+			c.JSON(http.StatusConflict, (&apiError{Code: "wrong_borrower", Message: "Book is not checked out by " + from}).body())
+			return
+		}
+	}
+	if limit := cfg.MaxLoansPerBorrower; limit > 0 && !hasAdminKey(c, cfg.AdminKey) {
+		if n := activeLoanCount(to); n+1 > limit {
+			c.JSON(http.StatusForbidden, loanLimitError(n, limit).body())
+			return
+		}
+	}
+
+	l.Borrower = to
+	recordAuditReason(c, "transfer", id, fmt.Sprintf("from %s to %s", from, to))
+	catalogChanged()
+	renderJSON(c, http.StatusOK, *l)
+}
+
+// closeLoan marks l returned at now. Unless the book has been deleted since,
+// its copy is available again and the book's quantity goes back up by one.
+// The caller must hold the write lock, have checked the new quantity and
+// call catalogChanged.
+func closeLoan(l *loan, now time.Time) {
+	if book, err := getBookById(l.BookID); err == nil {
+		adjustQuantity(book, 1, "return", now)
+		if cp, ok := findCopy(book, l.CopyID); ok && cp.Status == copyCheckedOut {
+			cp.Status = copyAvailable
+			cp.LoanID = 0
+		}
+		syncCopies(book)
+		book.UpdatedAt = now
+	}
+	l.ReturnedAt = &now
+}
+
+// maxReturnItems is the maximum number of items returnBatch accepts.
+const maxReturnItems = 1000
+
+// returnItem is one item of the payload of returnBatch.
+type returnItem struct {
+	ID *int64 `json:"id"`
+	// Count is the number of copies returned, 1 when omitted.
+	Count *int `json:"count"`
+}
+
+// returnResult is the outcome of one item of returnBatch.
+type returnResult struct {
+	ID int64 `json:"id"`
+	// Loans are the IDs of the loans closed.
+	Loans    []int `json:"loans"`
+	Quantity int   `json:"quantity"`
+}
+
+// returnBatch handles the HTTP request to return several books at once,
+// such as a stack handed back by a borrower. It expects a JSON array like
+// [{"id": 1, "count": 2}, {"id": 3}] and, for each item, returns count
+// copies of the book, one by default, by closing its oldest active loans
+// as returnLoan does.
+//
+// The batch is all or nothing: every item is checked before any loan is
+// closed. It responds with 400 Bad Request for malformed JSON, 422
+// Unprocessable Entity for an empty batch, too many items, an item without
+// an id or with a non-positive count, or when the returned copies would
+// push a quantity past the configured maximum, 404 Not Found with the ID of
+// the first book that doesn't exist, 409 Conflict when a book has fewer
+// copies checked out than returned, and 200 OK otherwise with, per item,
+// the loans closed and the book's new quantity.
+func returnBatch(c *gin.Context) {
+	var items []returnItem
+	if err := c.ShouldBindJSON(&items); err != nil {
+		c.JSON(http.StatusBadRequest, invalidRequest("Invalid JSON, expected an array of items").body())
+		return
+	}
+	if len(items) == 0 {
+		c.JSON(http.StatusUnprocessableEntity, invalidRequest("No items to return").body())
+		return
+	}
+	if len(items) > maxReturnItems {
+		c.JSON(http.StatusUnprocessableEntity, invalidRequest("Too many items, the maximum is "+strconv.Itoa(maxReturnItems)).body())
+		return
+	}
+	for i, item := range items {
+		if item.ID == nil {
+			c.JSON(http.StatusUnprocessableEntity, invalidRequest(fmt.Sprintf("Item %d has no id", i)).body())
+			return
+		}
+		if item.Count != nil && *item.Count <= 0 {
+			c.JSON(http.StatusUnprocessableEntity, invalidRequest(fmt.Sprintf("Item %d has a non-positive count", i)).body())
+			return
+		}
+	}
+
+	booksMu.Lock()
+	defer booksMu.Unlock()
+	// returned is the total number of copies of each book returned, since
+	// a book may be listed more than once.
+	returned := map[int64]int{}
+	for _, item := range items {
+		id := *item.ID
+		book, err := getBookById(id)
+		if err != nil {
+			body := errBookNotFound.body()
+			body["id"] = id
+			c.JSON(http.StatusNotFound, body)
+			return
+		}
+		returned[id] += returnCount(item)
+		if n := len(activeLoans(id)); n < returned[id] {
+			body := (&apiError{Code: "too_many_returned", Message: fmt.Sprintf("Book %d has %d copies checked out, fewer than returned", id, n)}).body()
+			body["id"] = id
+			c.JSON(http.StatusConflict, body)
+			return
+		}
+		if err := checkQuantity(book.Quantity + returned[id]); err != nil {
+			body := errorBody(err)
+			body["id"] = id
+			c.JSON(validationStatus(err), body)
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	results := make([]returnResult, 0, len(items))
+	for _, item := range items {
+		r := returnResult{ID: *item.ID, Loans: []int{}}
+		for _, l := range activeLoans(r.ID)[:returnCount(item)] {
+			closeLoan(l, now)
+			recordAudit(c, "return", r.ID)
+			r.Loans = append(r.Loans, l.ID)
+		}
+		book, _ := getBookById(r.ID)
+		r.Quantity = book.Quantity
+		results = append(results, r)
+	}
+	catalogChanged()
+	renderJSON(c, http.StatusOK, results)
+}
+
+// returnCount returns the number of copies returned by item.
+func returnCount(item returnItem) int {
+	if item.Count == nil {
+		return 1
+	}
+	return *item.Count
+}
+
+// activeLoans returns the active loans of the book with the given ID,
+// oldest first. The caller must hold booksMu.
+func activeLoans(bookID int64) []*loan {
+	var active []*loan
+	for i := range loans {
+		if loans[i].BookID == bookID && loans[i].isActive() {
+			active = append(active, &loans[i])
+		}
+	}
+	return active
+}
@@ -1,39 +1,244 @@
 package main
 
 import (
-	"errors"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-// book represents a book with its essential details.
+// book represents a book with its essential details, as it is stored.
+// Handlers return a bookResponse built from it rather than the book itself.
 // The struct uses JSON tags to specify the field names when the struct is
 // marshaled or unmarshaled from JSON. These tags ensure that the JSON
 // representation of the struct uses the specified names, making it easier
 // to work with external systems or APIs that rely on JSON data.
+//
+// IDs are 64-bit integers sent as JSON numbers. They are limited to
+// ±(2^53-1), the range JavaScript clients can represent exactly.
+//
+// CreatedAt, UpdatedAt and DeletedAt are set by the server; any values sent
+// by the client are ignored.
 type book struct {
-	ID       int    `json:"id"`
+	ID       int64  `json:"id"`
 	Title    string `json:"title"`
 	Author   string `json:"author"`
 	Quantity int    `json:"quantity"`
+	Genre    string `json:"genre"`
+	ISBN     string `json:"isbn,omitempty"`
+	// Checkoutable is false for reference copies, which may be consulted
+	// but never checked out. It defaults to true when omitted.
+	Checkoutable bool `json:"checkoutable"`
+	// Tags are lower-case labels such as "fiction", sorted and unique.
+	Tags []string `json:"tags,omitempty"`
+	// Position orders the curated catalog, starting at 1. Zero means the
+	// book has no position and sorts after every positioned book.
+	Position int `json:"position,omitempty"`
+	// Copies tracks each physical copy of the book. The available ones
+	// always number Quantity; see syncCopies.
+	Copies []bookCopy `json:"copies,omitempty"`
+	// Ledger records every change to Quantity; see adjustQuantity.
+	Ledger []ledgerEntry `json:"ledger,omitempty"`
+	// FieldTimes holds the last modification time of each tracked field
+	// when FieldTimes is configured; see touchField.
+	FieldTimes map[string]time.Time `json:"field_times,omitempty"`
+	CreatedAt  time.Time            `json:"created_at"`
+	UpdatedAt  time.Time            `json:"updated_at"`
+	// DeletedAt is set when the book is deleted. Deleted books are kept
+	// but hidden from every read.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// isDeleted reports whether the book has been soft-deleted.
+func (b book) isDeleted() bool {
+	return b.DeletedAt != nil
+}
+
+// UnmarshalJSON decodes a book like the default decoder, except that
+// Checkoutable is true unless the JSON sets it, so requests and saved
+// catalogs predating the field keep books lendable.
+func (b *book) UnmarshalJSON(data []byte) error {
+	type plainBook book
+	p := plainBook{Checkoutable: true}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*b = book(p)
+	return nil
 }
 
+// startedAt is the time the service started, used as the creation time of
+// the seed books.
+var startedAt = time.Now().UTC()
+
 // books is a slice of book structs
 var books = []book{
-	{ID: 1, Title: "The Go Programming Language", Author: "Brian Kernighan", Quantity: 2},
-	{ID: 2, Title: "Concurrency in Go", Author: "Katherine Cox-Buday", Quantity: 5},
-	{ID: 3, Title: "Head First Go", Author: "Jay McGavren", Quantity: 6},
+	{ID: 1, Title: "The Go Programming Language", Author: "Brian Kernighan", Quantity: 2, Genre: "Programming", ISBN: "9780134190440", Checkoutable: true, CreatedAt: startedAt, UpdatedAt: startedAt},
+	{ID: 2, Title: "Concurrency in Go", Author: "Katherine Cox-Buday", Quantity: 5, Genre: "Programming", ISBN: "9781491941195", Checkoutable: true, CreatedAt: startedAt, UpdatedAt: startedAt},
+	{ID: 3, Title: "Head First Go", Author: "Jay McGavren", Quantity: 6, Genre: "Programming", ISBN: "9781491962558", Checkoutable: true, CreatedAt: startedAt, UpdatedAt: startedAt},
 }
 
+// defaultRecentLimit is the number of books returned by getRecentBooks when
+// no limit is given.
+const defaultRecentLimit = 10
+
 //   - c: A pointer to the Gin context, which contains information about the
 //     HTTP request and is used to construct the response.
 //
 // The function retrieves the `books` slice and sends it as a JSON response
-// to the client.
+// to the client. The optional query parameters are applied in this order:
+//  1. Filtering by `author` (substring) and `genre` (exact), both case-insensitive, by `available` (true or false)
+//     and by the inclusive ID range `id_from` to `id_to`. With `since`, an RFC 3339 time, only the books
+//     created, changed or deleted after it are listed, the deleted ones as tombstones with their `deleted_at`,
+//     so clients can sync incrementally.
+//  2. Sorting by `sort` (id, title, author, genre, quantity, created_at or position) in the `order` asc or desc.
+//     Several comma-separated keys sort by each in turn, and each may set its own direction,
+//     e.g. `sort=author:asc,title:desc`.
+//  3. Paginating with `offset` and `limit`.
+//
+// The X-Total-Count header holds the number of books matching the filters
+// before pagination, so clients can compute the number of pages. When a
+// limit is given, the Link header holds the URLs of the first, previous,
+// next and last pages; see paginationLinks.
+//
+// Clients that ask for it get the list wrapped as {"data": [...], "meta": {...}},
+// where meta holds the pagination, sort and filter details; see wantsEnvelope.
+//
+// When BooksCache is configured, the serialized response is cached per
+// query string and reused until the catalog changes.
+//
+// As an alternative to `offset` and `limit`, the page may be requested with
+// a header such as `Range: items=0-19`, where the end is inclusive and may
+// be left out. It takes precedence over the query parameters and is
+// answered with 206 Partial Content and `Content-Range: items 0-19/total`,
+// or 416 Range Not Satisfiable for a malformed range or one starting past
+// the end of the list. Ranges in other units are ignored.
+//
+// The response is cacheable by clients: it carries a weak ETag and honors
+// If-None-Match; see writeCacheable.
+//
+// When the client goes away before the list is ready, the work stops and
+// nothing is written; see requestCanceled.
 func getBooks(c *gin.Context) {
-	c.IndentedJSON(http.StatusOK, books)
+	envelope := wantsEnvelope(c)
+	cacheKey := c.Request.URL.RawQuery
+	if envelope {
+		cacheKey += "#" + envelopeProfile
+	}
+	rangeHeader := c.GetHeader("Range")
+	c.Header("Accept-Ranges", "items")
+	c.Writer.Header().Add("Vary", "Range")
+	useCache := cfg.BooksCache && rangeHeader == ""
+	if useCache {
+		if entry, ok := booksCache.get(cacheKey); ok {
+			c.Header("X-Total-Count", strconv.Itoa(entry.total))
+			if link := paginationLinks(c, entry.offset, entry.limit, entry.total); link != "" {
+				c.Header("Link", link)
+			}
+			writeCacheable(c, http.StatusOK, entry.body, true)
+			return
+		}
+	}
+
+	q, err := parseListQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorBody(err))
+		return
+	}
+	rng, hasRange, err := parseItemsRange(rangeHeader)
+	if err != nil {
+		c.JSON(http.StatusRequestedRangeNotSatisfiable, (&apiError{Code: "range_not_satisfiable", Message: err.Error()}).body())
+		return
+	}
+	booksMu.RLock()
+	defer booksMu.RUnlock()
+	span := startSpan(c, "store.list")
+	result, err := filterBooks(c.Request.Context(), books, q)
+	if err != nil {
+		span.End()
+		requestCanceled(c)
+		return
+	}
+	sortBooks(result, q)
+	total := len(result)
+	if hasRange {
+		if rng.first >= total {
+			span.End()
+			c.Header("Content-Range", "items */"+strconv.Itoa(total))
+			c.JSON(http.StatusRequestedRangeNotSatisfiable, (&apiError{Code: "range_not_satisfiable", Message: "Range not satisfiable, there are " + strconv.Itoa(total) + " books"}).body())
+			return
+		}
+		q.Offset, q.Limit = rng.first, rng.count()
+	}
+	result = paginate(result, q)
+	span.End()
+	if requestCanceled(c) {
+		return
+	}
+
+	page := newBookResponses(result)
+	var response any = page
+	if envelope {
+		response = newListEnvelope(page, total, q)
+	}
+
+	body, err := marshalJSON(c, response)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errInternal.body())
+		return
+	}
+	if useCache {
+		booksCache.put(cacheKey, cachedList{body: body, total: total, offset: q.Offset, limit: q.Limit})
+	}
+	c.Header("X-Total-Count", strconv.Itoa(total))
+	if hasRange {
+		c.Header("Content-Range", fmt.Sprintf("items %d-%d/%d", q.Offset, q.Offset+len(result)-1, total))
+		writeCacheable(c, http.StatusPartialContent, body, true)
+		return
+	}
+	if link := paginationLinks(c, q.Offset, q.Limit, total); link != "" {
+		c.Header("Link", link)
+	}
+	writeCacheable(c, http.StatusOK, body, true)
+}
+
+// getRecentBooks handles the HTTP request for the most recently added books.
+// It returns up to `limit` books (default 10) sorted by CreatedAt, newest
+// first, then by ID. An empty catalog yields an empty array.
+func getRecentBooks(c *gin.Context) {
+	limit := defaultRecentLimit
+	if limitStr, ok := c.GetQuery("limit"); ok {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, invalidRequest("Invalid limit").body())
+			return
+		}
+		limit = n
+	}
+
+	booksMu.RLock()
+	recent := slices.DeleteFunc(slices.Clone(books), book.isDeleted)
+	booksMu.RUnlock()
+	slices.SortStableFunc(recent, func(a, b book) int {
+		if n := b.CreatedAt.Compare(a.CreatedAt); n != 0 {
+			return n
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+	if len(recent) > limit {
+		recent = recent[:limit]
+	}
+	renderJSON(c, http.StatusOK, newBookResponses(recent))
 }
 
 // createBooks handles the HTTP request to create a new book.
@@ -42,97 +247,662 @@ func getBooks(c *gin.Context) {
 // The function performs the following steps:
 // 1. Attempts to bind the JSON request body to the `newBook` variable.
 // 2. If binding fails (due to invalid JSON), it responds with a 400 Bad Request status and an error message.
-// 3. If binding is successful, the new book is appended to the `books` slice.
-// 4. Responds with a 201 Created status and the newly created book in the response body.
+//    When the quantity is omitted, the configured default quantity is used; an explicit 0 is honored.
+//    The ID and quantity may be sent as numeric strings such as "5" unless StrictNumbers is configured;
+//    a string that isn't a number is rejected with 422 Unprocessable Entity, as is an ID or quantity
+//    that isn't a finite integer its field can hold, such as 2.5, 1e400 or "NaN".
+// 3. Normalizes the title and author so the same author is always spelled the same way.
+// 4. If the title is empty, the quantity is out of the configured bounds, or the ISBN is given but is not a valid ISBN-10 or ISBN-13,
+//    it responds with a 422 Unprocessable Entity status: the JSON was well-formed but the book it describes is not valid.
+// 5. If a book with the same ID or ISBN already exists, it responds with a 409 Conflict status.
+//    If the catalog already holds the configured MaxBooks, it responds with a 507 Insufficient Storage status.
+// 6. Sets the creation and update times and appends the new book to the `books` slice.
+// 7. Responds with a 201 Created status, a Location header pointing at the new book and the newly created book in the response body.
+//    With a `Prefer: return=minimal` header the body is left empty and `Preference-Applied` confirms it.
 
 func createBooks(c *gin.Context) {
 
-	var newBook book
-	if err := c.BindJSON(&newBook); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+	data, err := c.GetRawData()
+	if err != nil {
+		renderJSON(c, http.StatusBadRequest, errInvalidJSON.body())
+		return
+	}
+	newBook, err := decodeNewBook(data)
+	if err != nil {
+		status, err := decodeStatus(err)
+		renderJSON(c, status, errorBody(err))
+		return
+	}
+
+	booksMu.Lock()
+	defer booksMu.Unlock()
+	if err := validateNewBook(newBook); err != nil {
+		c.JSON(validationStatus(err), errorBody(err))
+		return
+	}
+	if full := checkCapacity(1); full != nil {
+		c.JSON(http.StatusInsufficientStorage, full.body())
+		return
+	}
+	insertBook(&newBook, time.Now().UTC(), "create")
+	recordAudit(c, "create", newBook.ID)
+	catalogChanged()
+	c.Header("Location", cfg.BasePath+"/books/"+strconv.FormatInt(newBook.ID, 10))
+	if prefers(c, "return=minimal") {
+		c.Header("Preference-Applied", "return=minimal")
+		c.Status(http.StatusCreated)
 		return
 	}
-	books = append(books, newBook)
-	c.IndentedJSON(http.StatusCreated, newBook)
+	renderJSON(c, http.StatusCreated, singleBook(c, newBook))
+}
+
+// normalizeBook trims surrounding whitespace from the title and author,
+// collapses repeated spaces inside the author name, strips the ISBN
+// separators and lower-cases, sorts and deduplicates the tags. When
+// TitleCaseAuthors is configured, the author is also title-cased.
+func normalizeBook(b *book) {
+	b.Title = strings.TrimSpace(b.Title)
+	b.ISBN = normalizeISBN(b.ISBN)
+	b.Author = strings.Join(strings.Fields(b.Author), " ")
+	if cfg.TitleCaseAuthors {
+		b.Author = titleCase(b.Author)
+	}
+	b.Tags = normalizeTags(b.Tags)
+}
+
+// titleCase upper-cases the first letter of every word and lower-cases the
+// rest. A letter starts a word when it follows anything other than a letter,
+// so "cox-buday" becomes "Cox-Buday" and "o'brien" becomes "O'Brien".
+func titleCase(s string) string {
+	var sb strings.Builder
+	prevLetter := false
+	for _, r := range s {
+		if prevLetter {
+			sb.WriteRune(unicode.ToLower(r))
+		} else {
+			sb.WriteRune(unicode.ToUpper(r))
+		}
+		prevLetter = unicode.IsLetter(r)
+	}
+	return sb.String()
 }
 
 // bookById retrieves a book by its ID from the URL parameter and returns it as a JSON response.
 // If the ID is invalid or if the book is not found, it responds with an appropriate HTTP status code and error message.
+// With `suggest=true`, a 404 also lists books whose ID is a near miss for the one requested, to help with typos;
+// see suggestBooks. The default 404 skips that work.
+// A found book is cacheable by clients: it carries a strong ETag and honors If-None-Match; see writeCacheable.
+// Error responses are not cacheable.
 func bookById(c *gin.Context) {
 	idStr := c.Param("id")
-	id, err := strconv.Atoi(idStr)
+	if idStr == "" {
+		c.JSON(http.StatusBadRequest, errInvalidBookID.body())
+		return
+	}
+	id, err := parseBookID(idStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		c.JSON(http.StatusBadRequest, errInvalidBookID.body())
 		return
 	}
+	booksMu.RLock()
+	defer booksMu.RUnlock()
+	span := startSpan(c, "store.lookup", attribute.Int64("book.id", id))
 	book, err := getBookById(id)
+	span.End()
+	if err != nil {
+		if suggest, _ := strconv.ParseBool(c.Query("suggest")); suggest {
+			body := errBookNotFound.body()
+			body["suggestions"] = suggestBooks(id)
+			c.JSON(http.StatusNotFound, body)
+			return
+		}
+		c.JSON(http.StatusNotFound, errBookNotFound.body())
+		return
+	}
+	body, err := marshalJSON(c, singleBook(c, *book))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"message": "Book not found."})
+		c.JSON(http.StatusInternalServerError, errInternal.body())
+		return
+	}
+	writeCacheable(c, http.StatusOK, body, false)
+}
+
+// maxExistsIDs is the maximum number of IDs booksExist checks at once.
+const maxExistsIDs = 1000
+
+// booksExist handles the HTTP request to check which of a set of book IDs exist.
+// It expects a JSON payload such as {"ids": [1, 2, 99]} and responds with an
+// object mapping each ID to whether a book with that ID exists, e.g.
+// {"1": true, "2": true, "99": false}.
+// If the payload is not an object with an array of integers, or holds more than 1000 IDs, it responds with a 400 Bad Request status.
+func booksExist(c *gin.Context) {
+	var req struct {
+		IDs []int64 `json:"ids" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, invalidRequest("Invalid JSON, expected an object with an array of integer ids").body())
+		return
+	}
+	if len(req.IDs) > maxExistsIDs {
+		c.JSON(http.StatusBadRequest, invalidRequest("Too many IDs, the maximum is "+strconv.Itoa(maxExistsIDs)).body())
 		return
 	}
-	c.IndentedJSON(http.StatusOK, book)
+
+	booksMu.RLock()
+	defer booksMu.RUnlock()
+	exists := make(map[int64]bool, len(req.IDs))
+	for _, id := range req.IDs {
+		_, err := getBookById(id)
+		exists[id] = err == nil
+	}
+	renderJSON(c, http.StatusOK, exists)
 }
 
-// getBookById searches for a book in the books slice by its ID and returns the book if found.
-// If the book is not found, it returns an error indicating that the book was not found.
+// getBookById looks up a book by its ID in the ID index and returns the book if found.
+// If the book is not found or has been deleted, it returns an error indicating that the book was not found.
+// The caller must hold booksMu for as long as it uses the returned pointer.
 //
-// @param id int - The ID of the book to search for.
+// @param id int64 - The ID of the book to search for.
 // @return (*book, error) - A pointer to the book if found, or nil if not found, along with an error indicating the result of the search.
-func getBookById(id int) (*book, error) {
-	for i, b := range books {
-		if b.ID == id {
-			return &books[i], nil
+func getBookById(id int64) (*book, error) {
+	if i, ok := bookIndex[id]; ok && !books[i].isDeleted() {
+		return &books[i], nil
+	}
+	return nil, errBookNotFound
+}
+
+// updateBook handles the HTTP request to replace the details of an existing book.
+// It expects a JSON payload like createBooks; the ID comes from the URL and any ID in the payload is ignored.
+//
+// When MaxQuantityDelta is configured, an update changing the quantity by more than that is rejected
+// with 422 Unprocessable Entity unless the request has an `X-Force: true` header, to catch fat-fingered values
+// and buggy inventory syncs. Forced overrides are logged.
+//
+// It responds with 400 Bad Request for invalid JSON, 422 Unprocessable Entity for an empty title,
+// a quantity that isn't a finite integer or is out of bounds, or an invalid ISBN, 404 Not Found when the book doesn't exist,
+// 409 Conflict when the ISBN belongs to another book, and 200 OK with the updated book otherwise.
+func updateBook(c *gin.Context) {
+	id, err := parseBookID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errInvalidBookID.body())
+		return
+	}
+	data, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errInvalidJSON.body())
+		return
+	}
+	// Numbers sent as strings are taken as on create, and non-finite or
+	// out-of-range ones are rejected before they fail to decode.
+	data, err = coerceNumbers(data)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errorBody(err))
+		return
+	}
+	var update book
+	if err := json.Unmarshal(data, &update); err != nil {
+		c.JSON(http.StatusBadRequest, errInvalidJSON.body())
+		return
+	}
+	normalizeBook(&update)
+	if err := validateFields(update); err != nil {
+		c.JSON(validationStatus(err), errorBody(err))
+		return
+	}
+
+	booksMu.Lock()
+	defer booksMu.Unlock()
+	book, err := getBookById(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errBookNotFound.body())
+		return
+	}
+	applyUpdate(c, book, update)
+}
+
+// applyUpdate replaces the editable fields of book with those of the
+// validated update and responds with the result. It rejects an ISBN taken
+// by another book with 409 Conflict and enforces the MaxQuantityDelta
+// guard. The caller must hold the write lock.
+func applyUpdate(c *gin.Context, book *book, update book) {
+	if other, exists := getBookByISBN(update.ISBN); exists && other.ID != book.ID {
+		c.JSON(http.StatusConflict, errDuplicateISBN.body())
+		return
+	}
+	if delta := update.Quantity - book.Quantity; cfg.MaxQuantityDelta > 0 && (delta > cfg.MaxQuantityDelta || -delta > cfg.MaxQuantityDelta) {
+		if c.GetHeader("X-Force") != "true" {
+			c.JSON(http.StatusUnprocessableEntity, (&apiError{Code: "quantity_change_too_large", Message: fmt.Sprintf("Quantity change of %d exceeds the limit of %d, send X-Force: true to apply it", delta, cfg.MaxQuantityDelta)}).body())
+			return
+		}
+		slog.Warn("forced quantity change", "book_id", book.ID, "from", book.Quantity, "to", update.Quantity, "client_ip", c.ClientIP())
+	}
+	now := time.Now().UTC()
+	touchChangedFields(book, update, now)
+	book.Title = update.Title
+	book.Author = update.Author
+	book.Genre = update.Genre
+	book.ISBN = update.ISBN
+	book.Checkoutable = update.Checkoutable
+	book.Tags = update.Tags
+	if delta := update.Quantity - book.Quantity; delta != 0 {
+		adjustQuantity(book, delta, "update", now)
+	}
+	syncCopies(book)
+	book.UpdatedAt = now
+	recordAudit(c, "update", book.ID)
+	catalogChanged()
+	renderJSON(c, http.StatusOK, singleBook(c, *book))
+}
+
+// deleteBook handles the HTTP request to delete a book by its ID.
+//
+// The book is soft-deleted: it is hidden from every read but kept, with its
+// deletion time, so stats can report on deleted books.
+//
+// Deleting is idempotent: the response is 204 No Content whether the book
+// was removed by this request or was already gone, so clients can safely
+// retry a delete whose response they never received. Only an ID that is not
+// a valid integer is rejected, with 400 Bad Request.
+func deleteBook(c *gin.Context) {
+	id, err := parseBookID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errInvalidBookID.body())
+		return
+	}
+	booksMu.Lock()
+	defer booksMu.Unlock()
+	if softDeleteBook(id, time.Now().UTC()) {
+		recordAudit(c, "delete", id)
+		catalogChanged()
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// deleteBooks handles the admin request to delete every book matching the
+// filters given as query parameters, for catalog cleanup: `author` and
+// `genre`, matched exactly but case-insensitively unlike the substring
+// match of getBooks, and `max_quantity`, matching the books with at most
+// that many copies. Books are soft-deleted like with deleteBook.
+//
+// As a guard against mass deletion by accident, at least one filter and
+// `confirm=true` are required, or it responds with 400 Bad Request.
+// Otherwise it responds with 200 OK, the number of books deleted and their
+// IDs.
+func deleteBooks(c *gin.Context) {
+	author := strings.ToLower(strings.Join(strings.Fields(c.Query("author")), " "))
+	genre := strings.ToLower(strings.TrimSpace(c.Query("genre")))
+	maxQuantity := -1
+	if s := c.Query("max_quantity"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, invalidRequest("Invalid max_quantity").body())
+			return
+		}
+		maxQuantity = n
+	}
+	if author == "" && genre == "" && maxQuantity < 0 {
+		c.JSON(http.StatusBadRequest, invalidRequest("At least one of the author, genre or max_quantity filters is required").body())
+		return
+	}
+	if c.Query("confirm") != "true" {
+		c.JSON(http.StatusBadRequest, (&apiError{Code: "confirmation_required", Message: "Deleting by filter requires confirm=true"}).body())
+		return
+	}
+
+	booksMu.Lock()
+	defer booksMu.Unlock()
+	now := time.Now().UTC()
+	deleted := []int64{}
+	for _, b := range books {
+		if b.isDeleted() ||
+			author != "" && strings.ToLower(b.Author) != author ||
+			genre != "" && strings.ToLower(b.Genre) != genre ||
+			maxQuantity >= 0 && b.Quantity > maxQuantity {
+			continue
 		}
+		softDeleteBook(b.ID, now)
+		recordAudit(c, "delete", b.ID)
+		deleted = append(deleted, b.ID)
+	}
+	if len(deleted) > 0 {
+		catalogChanged()
 	}
-	return nil, errors.New("book not found")
+	renderJSON(c, http.StatusOK, gin.H{"deleted": len(deleted), "ids": deleted})
+}
+
+// checkoutRequest holds the parameters of a checkout, read from the query
+// string by checkoutBook or from the JSON body by checkoutJSON.
+type checkoutRequest struct {
+	ID       *int64 `json:"id"`
+	ISBN     string `json:"isbn"`
+	Count    int    `json:"count"`
+	Copy     int    `json:"copy"`
+	Borrower string `json:"borrower"`
+	DueDate  string `json:"due_date"`
+	Receipt  bool   `json:"receipt"`
 }
 
 // checkoutBook handles the checkout process for a book.
-// It expects an "id" query parameter in the request URL, which represents the ID of the book to be checked out.
+// It expects either an "id" or an "isbn" query parameter in the request URL, which identifies the book to be checked out.
+// The "isbn" parameter lets barcode scanners check out a book by scanning it.
+// An optional "borrower" query parameter names who takes the copy, and an optional "due_date"
+// (RFC 3339 or YYYY-MM-DD, in the future) sets when it is due back instead of the configured loan period.
+// An optional "count" query parameter checks out that many copies at once, one by default.
 //
 // The function performs the following steps:
-// 1. Retrieves the "id" query parameter from the request.
-// 2. If the "id" parameter is missing, it responds with a 400 Bad Request status and a message indicating the missing parameter.
+// 1. Retrieves the "id" and "isbn" query parameters from the request.
+// 2. If both or neither are given, it responds with a 400 Bad Request status and a message indicating the expected parameters.
 // 3. Converts the "id" parameter from a string to an integer. If the conversion fails, it responds with a 400 Bad Request status and an error message.
 // 4. Fetches the book details using the provided ID. If the book is not found, it responds with a 404 Not Found status and a message indicating the book was not found.
-// 5. Checks if the book's quantity is greater than zero. If the book is out of stock, it responds with a 400 Bad Request status and a message indicating the book is not available.
-// 6. Decreases the book's quantity by one to reflect the checkout action.
+// 5. Checks that taking the copies keeps the quantity within the configured bounds. If the book is out of stock, it responds with a 400 Bad Request status and a message indicating the book is not available.
+// 6. If the borrower would exceed MaxLoansPerBorrower active loans, it responds with a 403 Forbidden status.
+//    Anonymous checkouts aren't limited, and admins sending the X-Admin-Key header may exceed the limit.
+//    When CheckoutCooldown is configured and the same client checked out the book within it, it responds
+//    with a 429 Too Many Requests status and the seconds left in the Retry-After header; admins are exempt.
+// 7. Decreases the book's quantity by the count to reflect the checkout action and records the update time.
+// 8. Takes the copy named by the optional "copy" query parameter, responding with 404 Not Found if the book has no such
+//    copy and 409 Conflict if it isn't available, or else the available copies with the lowest IDs.
+// 9. Opens a loan for the borrower and each copy. The loan IDs are returned, comma-separated, in the X-Loan-ID header
+//    and are needed to return the copies.
+// 10. Responds with the updated book or, when the "receipt" query parameter is true, with a printable receipt of the
+//    checkout holding the book, borrower, loan, copy, checkout time, due date and a generated receipt ID.
 //
 
 func checkoutBook(c *gin.Context) {
-	idStr, ok := c.GetQuery("id")
+	idStr, hasID := c.GetQuery("id")
+	isbn, hasISBN := c.GetQuery("isbn")
+
+	if hasID == hasISBN {
+		renderJSON(c, http.StatusBadRequest, invalidRequest("Exactly one of the id or isbn query parameters is required").body())
+		return
+	}
+	req := checkoutRequest{ISBN: isbn, Count: 1, Borrower: c.Query("borrower"), DueDate: c.Query("due_date")}
+	if s, ok := c.GetQuery("receipt"); ok {
+		var err error
+		if req.Receipt, err = strconv.ParseBool(s); err != nil {
+			c.JSON(http.StatusBadRequest, invalidRequest("Invalid receipt, must be true or false").body())
+			return
+		}
+	}
+	if hasID {
+		id, err := parseBookID(idStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errInvalidBookID.body())
+			return
+		}
+		req.ID = &id
+	}
+	if s, ok := c.GetQuery("copy"); ok {
+		var err error
+		if req.Copy, err = strconv.Atoi(s); err != nil || req.Copy <= 0 {
+			c.JSON(http.StatusBadRequest, invalidRequest("Invalid copy").body())
+			return
+		}
+	}
+	if s, ok := c.GetQuery("count"); ok {
+		var err error
+		if req.Count, err = strconv.Atoi(s); err != nil || req.Count <= 0 {
+			c.JSON(http.StatusBadRequest, invalidRequest("Invalid count").body())
+			return
+		}
+	}
+	checkout(c, req)
+}
+
+// checkoutJSON handles the HTTP request to check out a book with the
+// parameters of checkoutBook in a JSON body, such as
+// {"id": 1, "count": 2, "borrower": "Ada"}, for clients that prefer it to
+// query parameters. It responds exactly like checkoutBook, and with 400 Bad
+// Request for malformed JSON.
+func checkoutJSON(c *gin.Context) {
+	req := checkoutRequest{Count: 1}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, errInvalidJSON.body())
+		return
+	}
+	if (req.ID != nil) == (req.ISBN != "") {
+		renderJSON(c, http.StatusBadRequest, invalidRequest("Exactly one of id or isbn is required").body())
+		return
+	}
+	if req.ID != nil && checkBookID(*req.ID) != nil {
+		c.JSON(http.StatusBadRequest, errInvalidBookID.body())
+		return
+	}
+	if req.Copy < 0 {
+		c.JSON(http.StatusBadRequest, invalidRequest("Invalid copy").body())
+		return
+	}
+	if req.Count <= 0 {
+		c.JSON(http.StatusBadRequest, invalidRequest("Invalid count").body())
+		return
+	}
+	checkout(c, req)
+}
 
+// checkout checks out copies of a book as described by req, once the
+// request has been parsed by checkoutBook or checkoutJSON.
+func checkout(c *gin.Context, req checkoutRequest) {
+	if req.Count > 1 && (req.Copy != 0 || req.Receipt) {
+		c.JSON(http.StatusBadRequest, invalidRequest("A copy or a receipt can only be requested for a single copy").body())
+		return
+	}
+	borrower, ok := parseBorrower(req.Borrower)
 	if !ok {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "Missing query parameter"})
+		c.JSON(http.StatusBadRequest, invalidRequest("Borrower name too long").body())
 		return
 	}
-	id, err := strconv.Atoi(idStr)
+	now := time.Now().UTC()
+	due, err := parseDueDate(req.DueDate, now)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid ID"})
+		c.JSON(http.StatusBadRequest, errorBody(err))
 		return
 	}
-	book, err := getBookById(id)
-	if err != nil {
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "Book not found."})
+	booksMu.Lock()
+	defer booksMu.Unlock()
+	span := startSpan(c, "store.lookup")
+	var book *book
+	if req.ID != nil {
+		book, _ = getBookById(*req.ID)
+	} else {
+		book, _ = getBookByISBN(normalizeISBN(req.ISBN))
+	}
+	span.End()
+	if book == nil {
+		renderJSON(c, http.StatusNotFound, errBookNotFound.body())
+		return
+	}
+	if !book.Checkoutable {
+		renderJSON(c, http.StatusForbidden, errBookReferenceOnly.body())
+		return
+	}
+	if book.Quantity < req.Count || checkQuantity(book.Quantity-req.Count) != nil {
+		renderJSON(c, http.StatusBadRequest, errBookNotAvailable.body())
+		return
+	}
+	if limit := cfg.MaxLoansPerBorrower; limit > 0 && borrower != "" && !hasAdminKey(c, cfg.AdminKey) {
+		if n := activeLoanCount(borrower); n+req.Count > limit {
+			renderJSON(c, http.StatusForbidden, loanLimitError(n, limit).body())
+			return
+		}
+	}
+	if wait := checkoutCooldown(c, book.ID, now); wait > 0 {
+		seconds := int(math.Ceil(wait.Seconds()))
+		c.Header("Retry-After", strconv.Itoa(seconds))
+		body := (&apiError{Code: "checkout_too_soon", Message: fmt.Sprintf("Book was checked out from this client recently, try again in %d seconds", seconds)}).body()
+		body["retry_after"] = seconds
+		renderJSON(c, http.StatusTooManyRequests, body)
+		return
+	}
+	if req.Copy != 0 {
+		cp, ok := findCopy(book, req.Copy)
+		if !ok {
+			renderJSON(c, http.StatusNotFound, errCopyNotFound.body())
+			return
+		}
+		if cp.Status != copyAvailable {
+			renderJSON(c, http.StatusConflict, copyUnavailableError(cp).body())
+			return
+		}
+	} else if _, ok := firstAvailableCopy(book); !ok {
+		renderJSON(c, http.StatusBadRequest, errBookNotAvailable.body())
 		return
 	}
-	if book.Quantity <= 0 {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "Book not available."})
+
+	var loanIDs []string
+	var l loan
+	for range req.Count {
+		cp, _ := firstAvailableCopy(book)
+		if req.Copy != 0 {
+			cp, _ = findCopy(book, req.Copy)
+		}
+		span = startSpan(c, "store.checkout", attribute.Int64("book.id", book.ID), attribute.Int("copy.id", cp.ID))
+		adjustQuantity(book, -1, "checkout", now)
+		l = openLoan(book.ID, cp.ID, borrower, now, due)
+		cp.Status = copyCheckedOut
+		cp.LoanID = l.ID
+		span.End()
+		recordAudit(c, "checkout", book.ID)
+		loanIDs = append(loanIDs, strconv.Itoa(l.ID))
+	}
+	book.UpdatedAt = now
+	recordCheckout(c, book.ID, now)
+	catalogChanged()
+	c.Header("X-Loan-ID", strings.Join(loanIDs, ","))
+	if req.Receipt {
+		renderJSON(c, http.StatusOK, newReceipt(l, *book))
 		return
 	}
-	book.Quantity -= 1
-	c.IndentedJSON(http.StatusOK, book)
+	renderJSON(c, http.StatusOK, singleBook(c, *book))
+}
+
+// routeNotFound responds to requests for unknown paths with a 404 Not Found
+// in the same JSON error shape as the other handlers.
+func routeNotFound(c *gin.Context) {
+	c.JSON(http.StatusNotFound, errRouteNotFound.body())
 }
 
-// Creates a new Gin router instance with default middleware.
-// Registers the `getBooks` handler function to the "/books" route. This
-// route will handle GET requests to retrieve a list of books.
+// methodNotAllowed responds to requests for a known path with the wrong
+// method with a 405 Method Not Allowed. Gin sets the Allow header listing
+// the methods the path supports before calling it.
+func methodNotAllowed(c *gin.Context) {
+	c.JSON(http.StatusMethodNotAllowed, (&apiError{Code: "method_not_allowed", Message: "Method not allowed, use one of: " + c.Writer.Header().Get("Allow")}).body())
+}
+
+// main loads the configuration from the environment, sets up tracing,
+// loads the seed and the persisted catalog when they are configured, and
+// serves the router built by newRouter, where every middleware and route
+// is set up, until the process is told to stop; see serve.
 func main() {
-	router := gin.Default()
-	router.GET("/books", getBooks)
-	router.POST("/books", createBooks)
-	router.GET("/books/:id", bookById)
-	router.GET("/checkout", checkoutBook)
-	router.Run("localhost:8080")
+	cfg = loadConfig()
+	warnUnknownFeatures()
+	setupTracing()
+	if cfg.SeedURL != "" {
+		loadSeedURL(cfg.SeedURL, cfg.SeedTimeout)
+	} else if cfg.SeedFile != "" {
+		loadSeed(cfg.SeedFile)
+	}
+	if cfg.PersistFile != "" {
+		loadCatalog(cfg.PersistFile)
+		if !cfg.ReadOnly {
+			onShutdown("persistence", saveOnShutdown)
+		}
+	}
+	if cfg.ReadOnly {
+		slog.Info("read-only mode, writes are rejected")
+	}
+	syncAllCopies()
+	openAllLedgers()
+
+	serve(&http.Server{Addr: "localhost:8080", Handler: newRouter()}, cfg.ShutdownTimeout)
+}
+
+// newRouter returns the router serving the API under the configured base
+// path, with every middleware and route set up from cfg.
+func newRouter() *gin.Engine {
+	router := gin.New()
+	// Paths are matched exactly: /books/ or /BOOKS get a 404 rather than a
+	// redirect to /books, which clients following redirects would turn
+	// into a GET and lose the body of a POST or PUT.
+	router.RedirectTrailingSlash = false
+	router.RedirectFixedPath = false
+	router.HandleMethodNotAllowed = true
+	// Route on the escaped path so an encoded slash in a parameter, as in a
+	// title like "AC%2FDC", stays part of it. Parameters are still decoded.
+	router.UseRawPath = true
+	router.NoRoute(routeNotFound)
+	router.NoMethod(methodNotAllowed)
+	router.Use(trackRequests(), traceRequests())
+	router.Use(requestLogger(cfg.SlowRequestThreshold), gin.Recovery())
+	router.Use(gateFeatures())
+	router.Use(limitRequests(cfg.MaxConcurrentRequests))
+	router.Use(limitQueryLength(cfg.MaxQueryLength))
+	router.Use(cors(cfg.CORSAllowedOrigins, cfg.CORSMaxAge, cfg.CORSAllowCredentials))
+	router.Use(limitRate(cfg.RateLimit, cfg.RateLimitBurst, cfg.RateLimitMode))
+	router.Use(maintenance(cfg.MaintenanceRetryAfter, cfg.ReadOnly))
+	router.Use(gunzipBody(cfg.MaxDecompressedBody))
+	router.Use(verifySignature(cfg.SigningSecrets, cfg.SignatureMaxSkew))
+	setMaintenanceMode(cfg.MaintenanceMode)
+	// Every route is served under the base path, if any.
+	api := router.Group(cfg.BasePath)
+	api.GET("/books", getBooks)
+	api.POST("/books", createBooks)
+	// Listed in adminRoutes to keep it out of the root index.
+	api.DELETE("/books", requireAdmin(cfg.AdminKey), deleteBooks)
+	api.GET("/books/recent", getRecentBooks)
+	api.GET("/books/search", searchBooks)
+	api.GET("/books/search/advanced", advancedSearch)
+	api.POST("/books/exists", booksExist)
+	api.POST("/books/tags", tagBooks)
+	api.GET("/books/stats", getStats)
+	api.GET("/books/stats/by-genre", getStatsByGenre)
+	api.GET("/books/shrinkage", getShrinkage)
+	api.GET("/books/never-checked-out", getNeverCheckedOut)
+	api.GET("/books/compare", compareBooks)
+	api.GET("/books/schema", getBookSchema)
+	api.GET("/books/by-title/:title", getBooksByTitle)
+	api.PUT("/books/order", setOrder)
+	api.POST("/books/swap", swapPositions)
+
+	heavy := limitConcurrency(cfg.HeavyOpConcurrency)
+	api.GET("/books/export.csv", heavy, exportCSV)
+	api.GET("/books/export.ndjson", heavy, exportNDJSON)
+	api.POST("/books/import", heavy, importCSV)
+	api.POST("/books/import.ndjson", heavy, importNDJSON)
+
+	api.GET("/books/:id", bookById)
+	api.PUT("/books/:id", updateBook)
+	api.PATCH("/books/:id", patchBook)
+	api.DELETE("/books/:id", deleteBook)
+	api.POST("/books/:id/restock", restockBook)
+	api.POST("/books/:id/clone", cloneBook)
+	api.GET("/books/:id/loans", getBookLoans)
+	api.POST("/books/:id/transfer", transferLoan)
+	api.GET("/books/:id/copies", getBookCopies)
+	api.GET("/books/:id/availability", getAvailability)
+	api.GET("/books/:id/ledger", getBookLedger)
+	api.POST("/books/:id/damage", writeOffCopy(copyDamaged))
+	api.POST("/books/:id/lost", writeOffCopy(copyLost))
+	api.GET("/loans/overdue", getOverdueLoans)
+	api.POST("/loans/:id/return", returnLoan)
+	api.POST("/return/batch", returnBatch)
+	api.GET("/checkout", dedupCheckout(cfg.CheckoutDedupWindow), checkoutBook)
+	api.POST("/checkout", dedupCheckout(cfg.CheckoutDedupWindow), checkoutJSON)
+	api.GET("/isbn/validate", validateISBN)
+	api.GET("/health", health)
+	if cfg.BasePath == "" {
+		api.GET("/", getRoot)
+	} else {
+		// The index is served at the base path itself, e.g. /api, rather
+		// than /api/.
+		api.GET("", getRoot)
+	}
 
+	admin := api.Group("/admin", requireAdmin(cfg.AdminKey))
+	admin.GET("/health", healthDetails)
+	admin.POST("/reindex", reindex)
+	admin.GET("/audit", getAudit)
+	admin.GET("/audit/export", heavy, exportAuditCSV)
+	admin.GET("/features", getFeatures)
+	rootIndex = newAPIIndex(router.Routes())
+	return router
 }
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// builtinBooks is the builtin catalog, kept so every test starts from it.
+var builtinBooks = append([]book(nil), books...)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// newTestRouter resets the catalog to the builtin books, with no loans,
+// audit entries or cached responses, and returns a router configured with
+// the defaults as changed by configure, if not nil.
+func newTestRouter(t testing.TB, configure func(*config)) *gin.Engine {
+	t.Helper()
+	cfg = defaultConfig()
+	if configure != nil {
+		configure(&cfg)
+	}
+	booksMu.Lock()
+	books = append([]book(nil), builtinBooks...)
+	bookIndex = buildIndex(books)
+	loans = nil
+	nextLoanID = 1
+	persistLoaded = false
+	recentCheckouts = map[string]time.Time{}
+	booksMu.Unlock()
+	audit = &auditLog{}
+	booksCache = &listCache{entries: map[string]cachedList{}}
+	syncAllCopies()
+	openAllLedgers()
+	return newRouter()
+}
+
+// do sends a request with the given method, target and JSON body, if
+// any, to r and returns the recorded response.
+func do(r http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// mustCreate creates a book from its JSON and fails t unless it succeeds.
+func mustCreate(t testing.TB, r http.Handler, body string) {
+	t.Helper()
+	if w := do(r, http.MethodPost, "/books", body); w.Code != http.StatusCreated {
+		t.Fatalf("POST /books %s: status %d, body %s", body, w.Code, w.Body)
+	}
+}
+
+// decode decodes the JSON body of w into v and fails t if it can't.
+func decode(t testing.TB, w *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+}
+
+// bookIDs returns the IDs of bs in order.
+func bookIDs(bs []book) []int64 {
+	ids := make([]int64, len(bs))
+	for i, b := range bs {
+		ids[i] = b.ID
+	}
+	return ids
+}
+
+func TestGetBooksFilterSortPaginate(t *testing.T) {
+	r := newTestRouter(t, nil)
+	mustCreate(t, r, `{"id": 10, "title": "Zen and the Art of Go", "author": "Anna Coxwell"}`)
+	mustCreate(t, r, `{"id": 11, "title": "Algorithms in Go", "author": "Russ Cox"}`)
+	mustCreate(t, r, `{"id": 12, "title": "A Tour of Go", "author": "Rob Pike"}`)
+
+	tests := []struct {
+		query string
+		want  []int64
+	}{
+		{"author=cox&sort=title&limit=1&offset=0", []int64{11}},
+		{"author=cox&sort=title&limit=1&offset=1", []int64{2}},
+		{"author=cox&sort=title&order=desc&limit=2&offset=0", []int64{10, 2}},
+		{"author=cox&sort=title&limit=10&offset=2", []int64{10}},
+		{"author=cox&sort=title&limit=10&offset=3", []int64{}},
+	}
+	for _, tt := range tests {
+		w := do(r, http.MethodGet, "/books?"+tt.query, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /books?%s: status %d, body %s", tt.query, w.Code, w.Body)
+		}
+		var got []book
+		decode(t, w, &got)
+		if ids := bookIDs(got); !slices.Equal(ids, tt.want) {
+			t.Errorf("GET /books?%s = %v, want %v", tt.query, ids, tt.want)
+		}
+		// The total counts the matches before pagination.
+		if total := w.Header().Get("X-Total-Count"); total != "3" {
+			t.Errorf("GET /books?%s: X-Total-Count %q, want 3", tt.query, total)
+		}
+	}
+}
+
+// seedCatalog replaces the catalog with n generated books of quantity
+// copies each, spread over 100 authors and 10 genres, with no loans.
+func seedCatalog(n, quantity int) {
+	booksMu.Lock()
+	defer booksMu.Unlock()
+	now := time.Now().UTC()
+	books = make([]book, n)
+	for i := range books {
+		books[i] = book{
+			ID:           int64(i + 1),
+			Title:        fmt.Sprintf("Book %d", i+1),
+			Author:       fmt.Sprintf("Author %d", i%100),
+			Genre:        fmt.Sprintf("Genre %d", i%10),
+			Quantity:     quantity,
+			Checkoutable: true,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		}
+		syncCopies(&books[i])
+		openLedger(&books[i], "opening balance", now)
+	}
+	bookIndex = buildIndex(books)
+	loans = nil
+	catalogChanged()
+}
+
+// BenchmarkGetBooks lists a 10,000-book catalog, unfiltered.
+func BenchmarkGetBooks(b *testing.B) {
+	r := newTestRouter(b, nil)
+	seedCatalog(10000, 5)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		if w := do(r, http.MethodGet, "/books", ""); w.Code != http.StatusOK {
+			b.Fatalf("GET /books: status %d", w.Code)
+		}
+	}
+}
+
+// BenchmarkGetBooksCached is BenchmarkGetBooks with BooksCache on, so every
+// request after the first is served from the cache.
+func BenchmarkGetBooksCached(b *testing.B) {
+	r := newTestRouter(b, func(c *config) { c.BooksCache = true })
+	seedCatalog(10000, 5)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		if w := do(r, http.MethodGet, "/books", ""); w.Code != http.StatusOK {
+			b.Fatalf("GET /books: status %d", w.Code)
+		}
+	}
+}
+
+// BenchmarkGetBookById looks up the last book of catalogs of growing size.
+// Books are found through bookIndex, so the time per lookup stays flat
+// rather than growing with the catalog as a linear scan would.
+func BenchmarkGetBookById(b *testing.B) {
+	for _, n := range []int{100, 10000, 100000} {
+		b.Run(fmt.Sprintf("books=%d", n), func(b *testing.B) {
+			r := newTestRouter(b, nil)
+			seedCatalog(n, 1)
+			target := fmt.Sprintf("/books/%d", n)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				if w := do(r, http.MethodGet, target, ""); w.Code != http.StatusOK {
+					b.Fatalf("GET %s: status %d", target, w.Code)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkCheckoutBook checks out one copy at a time, going round the
+// books of a 1,000-book catalog.
+func BenchmarkCheckoutBook(b *testing.B) {
+	const n, quantity = 1000, 100
+	r := newTestRouter(b, nil)
+	seedCatalog(n, quantity)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := range b.N {
+		// Restock the catalog once every copy is checked out.
+		if i > 0 && i%(n*quantity) == 0 {
+			b.StopTimer()
+			seedCatalog(n, quantity)
+			b.StartTimer()
+		}
+		target := fmt.Sprintf("/checkout?id=%d", i%n+1)
+		if w := do(r, http.MethodGet, target, ""); w.Code != http.StatusOK {
+			b.Fatalf("GET %s: status %d, body %s", target, w.Code, w.Body)
+		}
+	}
+}
+
+// wantError fails t unless w is an error response with the given status
+// and code.
+func wantError(t testing.TB, w *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	var body struct {
+		Code string `json:"code"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if w.Code != status || body.Code != code {
+		t.Errorf("got status %d, code %q, want %d, %q; body %s", w.Code, body.Code, status, code, w.Body)
+	}
+}
+
+func TestUnknownRoute(t *testing.T) {
+	r := newTestRouter(t, nil)
+	for _, target := range []string{"/nope", "/books/1/nope", "/BOOKS"} {
+		w := do(r, http.MethodGet, target, "")
+		if w.Code != http.StatusNotFound {
+			t.Errorf("GET %s: status %d, want 404", target, w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			t.Errorf("GET %s: Content-Type %q, want JSON", target, ct)
+		}
+		var body map[string]string
+		decode(t, w, &body)
+		if body["code"] != "route_not_found" || body["message"] != "Route not found" {
+			t.Errorf("GET %s: body %v, want a JSON error", target, body)
+		}
+	}
+}
+
+func TestWrongMethod(t *testing.T) {
+	r := newTestRouter(t, nil)
+	w := do(r, http.MethodPost, "/health", "")
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST /health: status %d, want 405", w.Code)
+	}
+	if allow := w.Header().Get("Allow"); allow != "GET" {
+		t.Errorf("POST /health: Allow %q, want GET", allow)
+	}
+	var body map[string]string
+	decode(t, w, &body)
+	if body["code"] != "method_not_allowed" || body["message"] != "Method not allowed, use one of: GET" {
+		t.Errorf("POST /health: body %v, want a JSON error", body)
+	}
+}
+
+func TestRootIndexHidesAdminRoutes(t *testing.T) {
+	r := newTestRouter(t, func(c *config) { c.AdminKey = "secret" })
+	w := do(r, http.MethodGet, "/", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /: status %d, body %s", w.Code, w.Body)
+	}
+	var index apiIndex
+	decode(t, w, &index)
+	if len(index.Endpoints) == 0 {
+		t.Fatal("the root index lists no endpoints")
+	}
+	for _, e := range index.Endpoints {
+		if adminRoutes[e.Method+" "+e.Path] || strings.HasPrefix(e.Path, "/admin") {
+			t.Errorf("the root index lists the admin route %s %s", e.Method, e.Path)
+		}
+	}
+	if !slices.Contains(index.Endpoints, endpoint{Method: http.MethodGet, Path: "/books"}) {
+		t.Error("the root index doesn't list GET /books")
+	}
+}
+
+// matchesRoute reports whether the path, split in segments, is matched by
+// the route pattern, where a :param segment matches any segment.
+func matchesRoute(pattern string, segments []string) bool {
+	parts := strings.Split(pattern, "/")
+	if len(parts) != len(segments) {
+		return false
+	}
+	for i, part := range parts {
+		if !strings.HasPrefix(part, ":") && part != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestWrongMethodEveryRoute(t *testing.T) {
+	r := newTestRouter(t, nil)
+	methods := []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	for _, route := range r.Routes() {
+		target := route.Path
+		for _, param := range []string{":id", ":title"} {
+			target = strings.ReplaceAll(target, param, "1")
+		}
+		segments := strings.Split(target, "/")
+		// allowed holds the methods of every route matching the target,
+		// since a parameter may match a path of another route.
+		var allowed []string
+		for _, method := range methods {
+			for _, other := range r.Routes() {
+				if other.Method == method && matchesRoute(other.Path, segments) {
+					allowed = append(allowed, method)
+					break
+				}
+			}
+		}
+		for _, method := range methods {
+			if slices.Contains(allowed, method) {
+				continue
+			}
+			w := do(r, method, target, "")
+			if w.Code != http.StatusMethodNotAllowed {
+				t.Errorf("%s %s: status %d, want 405", method, target, w.Code)
+				continue
+			}
+			got := strings.Split(w.Header().Get("Allow"), ", ")
+			slices.SortFunc(got, func(a, b string) int { return slices.Index(methods, a) - slices.Index(methods, b) })
+			if !slices.Equal(got, allowed) {
+				t.Errorf("%s %s: Allow %v, want %v", method, target, got, allowed)
+			}
+		}
+	}
+}
+
+func TestTrailingSlash(t *testing.T) {
+	r := newTestRouter(t, nil)
+	// Paths are matched exactly rather than redirected, which would lose
+	// the body of a POST or PUT.
+	for _, tt := range []struct{ method, target string }{
+		{http.MethodGet, "/books/"},
+		{http.MethodGet, "/books/1/"},
+		{http.MethodPut, "/books/1/"},
+		{http.MethodGet, "/books//"},
+		{http.MethodGet, "/Books/1"},
+	} {
+		w := do(r, tt.method, tt.target, "")
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s %s: status %d, want 404", tt.method, tt.target, w.Code)
+		}
+		if loc := w.Header().Get("Location"); loc != "" {
+			t.Errorf("%s %s: redirected to %s", tt.method, tt.target, loc)
+		}
+	}
+}
+
+func TestBookByIdEmptyID(t *testing.T) {
+	newTestRouter(t, nil)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/books/", nil)
+	c.Params = gin.Params{{Key: "id", Value: ""}}
+	bookById(c)
+	wantError(t, w, http.StatusBadRequest, "invalid_id")
+}
+
+func TestSetOrderAuditsEachMove(t *testing.T) {
+	r := newTestRouter(t, nil)
+	for _, order := range []string{`[3, 1]`, `[3, 2]`} {
+		if w := do(r, http.MethodPut, "/books/order", order); w.Code != http.StatusOK {
+			t.Fatalf("PUT /books/order %s: status %d, body %s", order, w.Code, w.Body)
+		}
+	}
+	var moved []int64
+	for _, e := range audit.newestFirst() {
+		if e.Op == "reorder" {
+			moved = append(moved, e.BookID)
+		}
+	}
+	// Book 3 keeps its position the second time, so it is only audited
+	// once.
+	slices.Sort(moved)
+	if want := []int64{1, 1, 2, 3}; !slices.Equal(moved, want) {
+		t.Errorf("reordered books %v, want %v", moved, want)
+	}
+}
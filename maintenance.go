@@ -0,0 +1,65 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maintenanceMode is set while writes are temporarily rejected, e.g.
+// during a deploy or a data migration. Reads keep working.
+var maintenanceMode atomic.Bool
+
+// routeMutates overrides the method-based classification of isMutating for
+// routes whose method doesn't tell whether they change state, keyed by
+// method and route.
+var routeMutates = map[string]bool{
+	"GET /checkout":       true,
+	"POST /books/exists":  false,
+	"POST /admin/reindex": false,
+}
+
+// setMaintenanceMode turns maintenance mode on or off and logs the change.
+func setMaintenanceMode(on bool) {
+	if maintenanceMode.Swap(on) != on {
+		slog.Info("maintenance mode toggled", "enabled", on)
+	}
+}
+
+// isMutating reports whether the request changes the catalog.
+func isMutating(c *gin.Context) bool {
+	if mutates, ok := routeMutates[c.Request.Method+" "+routeOf(c)]; ok {
+		return mutates
+	}
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// maintenance rejects mutating requests with 503 Service Unavailable while
+// maintenance mode is on. The Retry-After header tells clients when it is
+// worth trying again.
+//
+// With readOnly, as for a reporting replica, mutating requests are always
+// rejected with 403 Forbidden instead, as no retry will ever succeed.
+func maintenance(retryAfter time.Duration, readOnly bool) gin.HandlerFunc {
+	seconds := strconv.Itoa(int(retryAfter.Seconds()))
+	return func(c *gin.Context) {
+		if readOnly && isMutating(c) {
+			c.AbortWithStatusJSON(http.StatusForbidden, errReadOnly.body())
+			return
+		}
+		if maintenanceMode.Load() && isMutating(c) {
+			c.Header("Retry-After", seconds)
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, errMaintenance.body())
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestMetrics tracks how busy the service is: the number of requests
+// being handled right now and the latency of those already handled since
+// startup. Together they tell whether an instance is saturated.
+type requestMetrics struct {
+	inFlight atomic.Int64
+	// rateLimitWarnings counts the requests that were over the rate limit
+	// but served anyway, as the limiter is in warn mode.
+	rateLimitWarnings atomic.Int64
+
+	mu           sync.Mutex
+	handled      int64
+	totalLatency time.Duration
+	maxLatency   time.Duration
+	// active holds the requests being handled, keyed by a sequence
+	// number, so those still running at shutdown can be reported.
+	active map[int64]activeRequest
+	nextID int64
+}
+
+// activeRequest describes a request being handled.
+type activeRequest struct {
+	Method   string
+	Path     string
+	ClientIP string
+	Start    time.Time
+}
+
+// metrics holds the request metrics of the service.
+var metrics = &requestMetrics{active: map[int64]activeRequest{}}
+
+// begin registers r as being handled and returns its key for end.
+func (m *requestMetrics) begin(r activeRequest) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	m.active[m.nextID] = r
+	return m.nextID
+}
+
+// end removes the request registered under key.
+func (m *requestMetrics) end(key int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.active, key)
+}
+
+// activeRequests returns the requests being handled right now.
+func (m *requestMetrics) activeRequests() []activeRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]activeRequest, 0, len(m.active))
+	for _, r := range m.active {
+		list = append(list, r)
+	}
+	return list
+}
+
+// record adds a handled request that took latency.
+func (m *requestMetrics) record(latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handled++
+	m.totalLatency += latency
+	m.maxLatency = max(m.maxLatency, latency)
+}
+
+// snapshot returns the metrics in the shape reported by healthDetails,
+// with latencies in milliseconds.
+func (m *requestMetrics) snapshot() gin.H {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var avg time.Duration
+	if m.handled > 0 {
+		avg = m.totalLatency / time.Duration(m.handled)
+	}
+	return gin.H{
+		"in_flight":           m.inFlight.Load(),
+		"handled":             m.handled,
+		"rate_limit_warnings": m.rateLimitWarnings.Load(),
+		"latency_ms": gin.H{
+			"avg": float64(avg.Microseconds()) / 1000,
+			"max": float64(m.maxLatency.Microseconds()) / 1000,
+		},
+	}
+}
+
+// trackRequests counts every request as in flight while it is handled and
+// records its latency afterwards. Both happen in a deferred call so a
+// panicking handler can't leave the counter stuck. It is registered before
+// every other middleware so the latency covers the whole chain.
+func trackRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		metrics.inFlight.Add(1)
+		key := metrics.begin(activeRequest{Method: c.Request.Method, Path: c.Request.URL.Path, ClientIP: c.ClientIP(), Start: start})
+		defer func() {
+			metrics.inFlight.Add(-1)
+			metrics.end(key)
+			metrics.record(time.Since(start))
+		}()
+		c.Next()
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/subtle"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// countingReader wraps a request body and counts the bytes read from it.
+// Content-Length is not reliable for chunked requests, so the size logged
+// is the number of bytes the handler actually consumed.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// requestLogger replaces Gin's default logger with a structured one.
+// Each request is logged once it has been handled, with the matched route
+// rather than the raw path so entries can be grouped per endpoint, and with
+// the request and response body sizes in bytes for capacity planning.
+//
+// Requests slower than slowThreshold are logged at WARN level as "slow
+// request" so they stand out; a zero threshold disables this.
+func requestLogger(slowThreshold time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		body := &countingReader{ReadCloser: c.Request.Body}
+		if c.Request.Body != nil {
+			c.Request.Body = body
+		}
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		respBytes := c.Writer.Size()
+		if respBytes < 0 {
+			respBytes = 0
+		}
+		latency := time.Since(start)
+		level, msg := slog.LevelInfo, "request"
+		if slowThreshold > 0 && latency > slowThreshold {
+			level, msg = slog.LevelWarn, "slow request"
+		}
+		slog.Log(c.Request.Context(), level, msg,
+			"method", c.Request.Method,
+			"route", route,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"latency", latency,
+			"client_ip", c.ClientIP(),
+			"req_bytes", body.n,
+			"resp_bytes", respBytes,
+		)
+	}
+}
+
+// corsExposedHeaders lists the response headers of the API that browsers
+// only let cross-origin scripts read when they are exposed.
+var corsExposedHeaders = []string{
+	"Content-Range", "ETag", "Link", "Location", "Retry-After", "X-Loan-ID",
+	"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "X-Total-Count",
+}
+
+// cors adds the CORS response headers for origins in the configured
+// allowlist and answers preflight requests directly. Access-Control-Max-Age
+// lets browsers cache the preflight result so they don't send an OPTIONS
+// request before every call, and Access-Control-Expose-Headers lets scripts
+// read the pagination and rate limit headers.
+//
+// With allowCredentials, browsers may send cookies and auth headers
+// cross-origin. The request's origin must then be listed explicitly, as
+// "*" is not allowed with credentials; it is reflected exactly along with
+// Access-Control-Allow-Credentials, and requests from any other origin are
+// rejected with 403 Forbidden.
+func cors(allowedOrigins []string, maxAge time.Duration, allowCredentials bool) gin.HandlerFunc {
+	allowAll := slices.Contains(allowedOrigins, "*") && !allowCredentials
+	if allowCredentials && slices.Contains(allowedOrigins, "*") {
+		slog.Warn("CORS wildcard origin ignored, credentialed CORS needs explicit origins")
+	}
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+		if !allowAll && !slices.Contains(allowedOrigins, origin) {
+			if allowCredentials {
+				c.AbortWithStatusJSON(http.StatusForbidden, (&apiError{Code: "origin_not_allowed", Message: "Origin not allowed"}).body())
+				return
+			}
+			c.Next()
+			return
+		}
+
+		h := c.Writer.Header()
+		if allowAll {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Add("Vary", "Origin")
+		}
+		if allowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		h.Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+
+		if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			h.Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Range, X-Force, X-Timestamp, X-Signature")
+			if maxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(int(maxAge.Seconds())))
+			}
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+	}
+}
+
+// limitQueryLength rejects requests whose raw query string is longer than
+// maxLen bytes with 414 URI Too Long, so handlers never parse huge,
+// filter-heavy query strings.
+func limitQueryLength(maxLen int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(c.Request.URL.RawQuery) > maxLen {
+			c.AbortWithStatusJSON(http.StatusRequestURITooLong, (&apiError{Code: "query_too_long", Message: "Query string too long, the maximum is " + strconv.Itoa(maxLen) + " bytes"}).body())
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireAdmin only lets requests through when their X-Admin-Key header
+// matches adminKey. With no key configured every request is rejected, so the
+// admin endpoints are off unless explicitly enabled.
+func requireAdmin(adminKey string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if adminKey == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, (&apiError{Code: "admin_not_configured", Message: "Admin access is not configured"}).body())
+			return
+		}
+		if !hasAdminKey(c, adminKey) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, (&apiError{Code: "invalid_admin_key", Message: "Invalid admin key"}).body())
+			return
+		}
+		c.Next()
+	}
+}
+
+// overloadRetryAfter is the Retry-After, in seconds, of requests rejected
+// by limitRequests.
+const overloadRetryAfter = 1
+
+// limitRequests lets at most n requests be handled at the same time and
+// rejects the others with 503 Service Unavailable and a Retry-After
+// header, as back-pressure under load. The /health liveness check is
+// exempt so a busy instance isn't mistaken for a dead one. A zero n
+// disables the limit.
+func limitRequests(n int) gin.HandlerFunc {
+	if n == 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+	sem := make(chan struct{}, n)
+	return func(c *gin.Context) {
+		if routeOf(c) == "/health" {
+			c.Next()
+			return
+		}
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+			c.Next()
+		default:
+			c.Header("Retry-After", strconv.Itoa(overloadRetryAfter))
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, (&apiError{Code: "server_busy", Message: "Server is busy, try again later"}).body())
+		}
+	}
+}
+
+// hasAdminKey reports whether the request carries the configured admin key
+// in its X-Admin-Key header. It is always false while no key is configured.
+func hasAdminKey(c *gin.Context, adminKey string) bool {
+	key := c.GetHeader("X-Admin-Key")
+	return adminKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(adminKey)) == 1
+}
+
+// limitConcurrency lets at most n requests through the handlers it guards
+// at the same time, across all clients, and rejects the others with 429 Too
+// Many Requests. Unlike per-client rate limiting, it protects the server
+// from too many expensive operations running at once.
+func limitConcurrency(n int) gin.HandlerFunc {
+	sem := make(chan struct{}, max(n, 1))
+	return func(c *gin.Context) {
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+			c.Next()
+		default:
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, (&apiError{Code: "too_many_bulk_operations", Message: "Too many bulk operations in progress, try again later"}).body())
+		}
+	}
+}
+
+// gunzipBody transparently decompresses request bodies sent with
+// "Content-Encoding: gzip", so handlers read plain JSON or CSV either way.
+// The body is decompressed up front, reading at most maxSize bytes: a body
+// that expands past that is rejected with 413 Request Entity Too Large
+// before any handler runs, which defuses zip bombs, and one that isn't
+// valid gzip is rejected with 400 Bad Request.
+func gunzipBody(maxSize int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !strings.EqualFold(c.GetHeader("Content-Encoding"), "gzip") || c.Request.Body == nil {
+			c.Next()
+			return
+		}
+		gz, err := gzip.NewReader(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, (&apiError{Code: "invalid_gzip", Message: "Malformed gzip body"}).body())
+			return
+		}
+		defer gz.Close()
+		data, err := io.ReadAll(io.LimitReader(gz, int64(maxSize)+1))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, (&apiError{Code: "invalid_gzip", Message: "Malformed gzip body"}).body())
+			return
+		}
+		if len(data) > maxSize {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, (&apiError{Code: "body_too_large", Message: "Decompressed body too large, the maximum is " + strconv.Itoa(maxSize) + " bytes"}).body())
+			return
+		}
+
+		c.Request.Body = io.NopCloser(bytes.NewReader(data))
+		c.Request.ContentLength = int64(len(data))
+		c.Request.Header.Del("Content-Encoding")
+		c.Request.Header.Set("Content-Length", strconv.Itoa(len(data)))
+		c.Next()
+	}
+}
+
+// statusClientClosedRequest is the non-standard status logged for requests
+// abandoned by the client before a response was written, as nginx does.
+const statusClientClosedRequest = 499
+
+// cancelCheckEvery is the number of books a long scan goes through between
+// checks that its request is still wanted.
+const cancelCheckEvery = 256
+
+// requestCanceled reports whether the client of c has gone away or the
+// request has otherwise been canceled. Handlers doing long work check it at
+// intervals and, once it is true, return without writing a response, which
+// nobody would read; the request is then logged with status 499.
+func requestCanceled(c *gin.Context) bool {
+	if c.Request.Context().Err() == nil {
+		return false
+	}
+	c.AbortWithStatus(statusClientClosedRequest)
+	return true
+}
+
+// routeOf returns the route matched by c without the base path, such as
+// "/books/:id", which is how routes are referred to throughout the code
+// whatever the base path. It is empty when no route matched.
+func routeOf(c *gin.Context) string {
+	return strings.TrimPrefix(c.FullPath(), cfg.BasePath)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCanceledRequest(t *testing.T) {
+	r := newTestRouter(t, nil)
+	seedCatalog(5000, 1)
+	tests := []struct {
+		target string
+		// logged tells whether the request is answered, and so logged,
+		// with status 499 rather than the 200 an export starts with.
+		logged bool
+	}{
+		{"/books", true},
+		{"/books?author=author+1&sort=title", true},
+		{"/books/search?q=book", true},
+		{"/books/export.csv", false},
+		{"/books/export.ndjson", false},
+	}
+	for _, tt := range tests {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil).WithContext(ctx))
+		if w.Body.Len() != 0 {
+			t.Errorf("GET %s: wrote %d bytes for a canceled request", tt.target, w.Body.Len())
+		}
+		if tt.logged && w.Code != statusClientClosedRequest {
+			t.Errorf("GET %s: status %d, want %d", tt.target, w.Code, statusClientClosedRequest)
+		}
+	}
+}
+
+// cancelingRecorder is a ResponseRecorder canceling its request at the
+// first flush, as if the client went away mid-response.
+type cancelingRecorder struct {
+	*httptest.ResponseRecorder
+	cancel context.CancelFunc
+}
+
+func (r *cancelingRecorder) Flush() {
+	r.cancel()
+	r.ResponseRecorder.Flush()
+}
+
+func TestCanceledExportStops(t *testing.T) {
+	const n = 25000
+	r := newTestRouter(t, nil)
+	seedCatalog(n, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := &cancelingRecorder{ResponseRecorder: httptest.NewRecorder(), cancel: cancel}
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/books/export.ndjson", nil).WithContext(ctx))
+
+	// The export stops at the next check after the first flush.
+	lines := bytes.Count(w.Body.Bytes(), []byte("\n"))
+	if lines == 0 || lines > ndjsonFlushEvery+cancelCheckEvery {
+		t.Errorf("exported %d of %d books after the client went away, want at most %d", lines, n, ndjsonFlushEvery+cancelCheckEvery)
+	}
+}
+
+func TestCORSRangeAndExposedHeaders(t *testing.T) {
+	r := newTestRouter(t, func(c *config) { c.CORSAllowedOrigins = []string{"https://app.example"} })
+
+	req := httptest.NewRequest(http.MethodOptions, "/books", nil)
+	req.Header.Set("Origin", "https://app.example")
+	req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	req.Header.Set("Access-Control-Request-Headers", "range")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if allowed := w.Header().Get("Access-Control-Allow-Headers"); !strings.Contains(allowed, "Range") {
+		t.Errorf("preflight: Access-Control-Allow-Headers %q, want Range", allowed)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/books", nil)
+	req.Header.Set("Origin", "https://app.example")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	exposed := w.Header().Get("Access-Control-Expose-Headers")
+	for _, name := range []string{"Content-Range", "Link", "X-Total-Count", "X-RateLimit-Remaining"} {
+		if !strings.Contains(exposed, name) {
+			t.Errorf("Access-Control-Expose-Headers %q, want %s", exposed, name)
+		}
+	}
+
+	// Origins not allowed get no CORS headers at all.
+	req = httptest.NewRequest(http.MethodGet, "/books", nil)
+	req.Header.Set("Origin", "https://evil.example")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if exposed := w.Header().Get("Access-Control-Expose-Headers"); exposed != "" {
+		t.Errorf("other origin: Access-Control-Expose-Headers %q, want none", exposed)
+	}
+}
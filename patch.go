@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// jsonPatchContentType is the media type of an RFC 6902 JSON Patch.
+const jsonPatchContentType = "application/json-patch+json"
+
+// patchOp is one operation of a JSON Patch document.
+type patchOp struct {
+	Op    string           `json:"op"`
+	Path  string           `json:"path"`
+	Value *json.RawMessage `json:"value"`
+}
+
+// patchableBook is the document a JSON Patch is applied to: the fields of
+// a book a client may edit, as in an update. The ID and timestamps are
+// managed by the server and can't be patched.
+type patchableBook struct {
+	Title        string   `json:"title"`
+	Author       string   `json:"author"`
+	Quantity     int      `json:"quantity"`
+	Genre        string   `json:"genre"`
+	ISBN         string   `json:"isbn"`
+	Checkoutable bool     `json:"checkoutable"`
+	Tags         []string `json:"tags"`
+}
+
+// errPatchTestFailed is returned by applyPatch when a test operation
+// doesn't match the book.
+var errPatchTestFailed = &apiError{Code: "patch_test_failed", Message: "Patch test failed"}
+
+// patchBook handles the HTTP request to edit an existing book with an RFC
+// 6902 JSON Patch sent as application/json-patch+json. The replace, add,
+// remove and test operations are supported on /title, /author, /quantity,
+// /genre, /isbn, /checkoutable and /tags; a failing test makes the whole patch fail, which lets
+// clients update a book only if it hasn't changed since they read it.
+//
+// The patched book is validated like an update. It responds with 415
+// Unsupported Media Type for any other content type, 400 Bad Request for
+// malformed JSON, 422 Unprocessable Entity for an invalid patch document or
+// an invalid result, 409 Conflict when a test fails or the ISBN belongs to
+// another book, 404 Not Found when the book doesn't exist, and 200 OK with
+// the updated book otherwise.
+func patchBook(c *gin.Context) {
+	id, err := parseBookID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errInvalidBookID.body())
+		return
+	}
+	if c.ContentType() != jsonPatchContentType {
+		c.JSON(http.StatusUnsupportedMediaType, invalidRequest("Content-Type must be "+jsonPatchContentType).body())
+		return
+	}
+	var ops []patchOp
+	if err := json.NewDecoder(c.Request.Body).Decode(&ops); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			c.JSON(http.StatusUnprocessableEntity, invalidRequest("Invalid patch, expected an array of operations").body())
+			return
+		}
+		c.JSON(http.StatusBadRequest, errInvalidJSON.body())
+		return
+	}
+
+	booksMu.Lock()
+	defer booksMu.Unlock()
+	book, err := getBookById(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errBookNotFound.body())
+		return
+	}
+	update, err := applyPatch(*book, ops)
+	if err != nil {
+		status := http.StatusUnprocessableEntity
+		if errors.Is(err, errPatchTestFailed) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, errorBody(err))
+		return
+	}
+	normalizeBook(&update)
+	if err := validateFields(update); err != nil {
+		c.JSON(validationStatus(err), errorBody(err))
+		return
+	}
+	applyUpdate(c, book, update)
+}
+
+// applyPatch applies ops in order to the editable fields of b and returns
+// the result. The operations are all applied or, on the first error, none.
+func applyPatch(b book, ops []patchOp) (book, error) {
+	raw, _ := json.Marshal(patchableBook{Title: b.Title, Author: b.Author, Quantity: b.Quantity, Genre: b.Genre, ISBN: b.ISBN, Checkoutable: b.Checkoutable, Tags: b.Tags})
+	var doc map[string]any
+	json.Unmarshal(raw, &doc)
+
+	for i, op := range ops {
+		field, err := patchField(op.Path)
+		if err != nil {
+			return b, fmt.Errorf("operation %d: %w", i, err)
+		}
+		var value any
+		if op.Op != "remove" {
+			if op.Value == nil {
+				return b, fmt.Errorf("operation %d: missing value", i)
+			}
+			json.Unmarshal(*op.Value, &value)
+		}
+		_, exists := doc[field]
+		switch op.Op {
+		case "add":
+			doc[field] = value
+		case "replace":
+			if !exists {
+				return b, fmt.Errorf("operation %d: %s doesn't exist", i, op.Path)
+			}
+			doc[field] = value
+		case "remove":
+			if !exists {
+				return b, fmt.Errorf("operation %d: %s doesn't exist", i, op.Path)
+			}
+			delete(doc, field)
+		case "test":
+			if !exists || !reflect.DeepEqual(doc[field], value) {
+				return b, fmt.Errorf("%w: %s doesn't match", errPatchTestFailed, op.Path)
+			}
+		default:
+			return b, fmt.Errorf("operation %d: unsupported op %q", i, op.Op)
+		}
+	}
+
+	raw, _ = json.Marshal(doc)
+	var patched patchableBook
+	if err := json.Unmarshal(raw, &patched); err != nil {
+		return b, errors.New("Invalid patch, a value has the wrong type")
+	}
+	b.Title = patched.Title
+	b.Author = patched.Author
+	b.Quantity = patched.Quantity
+	b.Genre = patched.Genre
+	b.ISBN = patched.ISBN
+	b.Checkoutable = patched.Checkoutable
+	b.Tags = patched.Tags
+	return b, nil
+}
+
+// patchField returns the field of patchableBook a JSON Pointer refers to.
+// Every field is a top-level member, so only pointers with a single
+// reference token are accepted.
+func patchField(path string) (string, error) {
+	switch path {
+	case "/title", "/author", "/quantity", "/genre", "/isbn", "/checkoutable", "/tags":
+		return strings.TrimPrefix(path, "/"), nil
+	}
+	return "", fmt.Errorf("path %q can't be patched", path)
+}
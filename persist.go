@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// persistLoaded reports whether the catalog was restored from the
+// persistence file at startup.
+var persistLoaded bool
+
+// persistedCatalog is the content of the persistence file: the books and
+// their loans, saved together since checked-out copies refer to loans.
+type persistedCatalog struct {
+	Books []book `json:"books"`
+	Loans []loan `json:"loans"`
+}
+
+// loadCatalog replaces the seed catalog and loans with those saved at path,
+// if any. A missing file is not an error: the service starts from the seed
+// data and creates the file on the first change.
+//
+// A file that can't be decoded is never fatal. It is logged, renamed with a
+// ".corrupt-<timestamp>" suffix so it can be inspected, and the service
+// starts from the seed data instead, so one bad write can't keep the
+// service down.
+func loadCatalog(path string) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		slog.Info("no persisted catalog, starting from seed data", "path", path)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to read persisted catalog, starting from seed data", "path", path, "error", err)
+		return
+	}
+
+	loaded, err := decodeCatalog(data)
+	if err != nil {
+		aside := fmt.Sprintf("%s.corrupt-%s", path, time.Now().UTC().Format("20060102T150405Z"))
+		if renameErr := os.Rename(path, aside); renameErr != nil {
+			slog.Error("persisted catalog is corrupt and could not be moved aside", "path", path, "error", err, "rename_error", renameErr)
+		} else {
+			slog.Error("persisted catalog is corrupt, moved aside and starting from seed data", "path", path, "moved_to", aside, "error", err)
+		}
+		return
+	}
+
+	booksMu.Lock()
+	defer booksMu.Unlock()
+	books = loaded.Books
+	bookIndex = buildIndex(books)
+	loans = loaded.Loans
+	nextLoanID = 1
+	for _, l := range loans {
+		nextLoanID = max(nextLoanID, l.ID+1)
+	}
+	released := releaseOrphanedCopies(time.Now().UTC())
+	persistLoaded = true
+	slog.Info("loaded persisted catalog", "path", path, "books", len(books), "loans", len(loans), "released_copies", released)
+}
+
+// releaseOrphanedCopies makes the checked-out copies whose loan isn't active
+// available again, putting them back in stock, and returns how many there
+// were. Such copies come from files saved before loans were persisted, and
+// could otherwise never be returned. The caller must hold the write lock.
+func releaseOrphanedCopies(now time.Time) int {
+	active := map[int]bool{}
+	for _, l := range loans {
+		if l.isActive() {
+			active[l.ID] = true
+		}
+	}
+	released := 0
+	for i := range books {
+		b := &books[i]
+		for j := range b.Copies {
+			cp := &b.Copies[j]
+			if cp.Status != copyCheckedOut || active[cp.LoanID] {
+				continue
+			}
+			cp.Status = copyAvailable
+			cp.LoanID = 0
+			adjustQuantity(b, 1, "release", now)
+			released++
+		}
+	}
+	return released
+}
+
+// gzipMagic is the header every gzip stream starts with.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// decodeCatalog parses a saved catalog and checks that book IDs are unique.
+// The catalog may be gzip-compressed, which is detected from its content
+// rather than the file name. A bare JSON array of books, as saved before
+// loans were persisted, is read as a catalog without loans.
+func decodeCatalog(data []byte) (persistedCatalog, error) {
+	if bytes.HasPrefix(data, gzipMagic) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return persistedCatalog{}, err
+		}
+		if data, err = io.ReadAll(zr); err != nil {
+			return persistedCatalog{}, err
+		}
+	}
+	var loaded persistedCatalog
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		if err := json.Unmarshal(data, &loaded.Books); err != nil {
+			return persistedCatalog{}, err
+		}
+	} else if err := json.Unmarshal(data, &loaded); err != nil {
+		return persistedCatalog{}, err
+	}
+	if loaded.Books == nil {
+		return persistedCatalog{}, errors.New("catalog has no array of books")
+	}
+	seen := make(map[int64]bool, len(loaded.Books))
+	for _, b := range loaded.Books {
+		if seen[b.ID] {
+			return persistedCatalog{}, fmt.Errorf("duplicate book ID %d", b.ID)
+		}
+		seen[b.ID] = true
+	}
+	return loaded, nil
+}
+
+// saveCatalog writes the catalog and its loans to path, gzip-compressed
+// when compress is set. The data is written to a temporary file in the
+// same directory and renamed over path, so a crash mid-write leaves the
+// previous file intact. The caller must hold booksMu.
+func saveCatalog(path string, compress bool) error {
+	data, err := json.MarshalIndent(persistedCatalog{Books: books, Loans: loans}, "", "    ")
+	if err != nil {
+		return err
+	}
+	if compress {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(data)
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		data = buf.Bytes()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// saveOnShutdown is the shutdown hook saving the catalog one last time.
+func saveOnShutdown(context.Context) error {
+	booksMu.Lock()
+	defer booksMu.Unlock()
+	return saveCatalog(cfg.PersistFile, cfg.PersistGzip)
+}
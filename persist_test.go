@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestLoadCatalogCorrupt(t *testing.T) {
+	tests := map[string]string{
+		"garbage":        "\x00\x01 not json at all",
+		"truncated":      `{"books": [{"id": 1, "title": "The Go Prog`,
+		"empty":          "",
+		"no books":       `{"loans": []}`,
+		"wrong type":     `{"books": {"id": 1}}`,
+		"duplicate IDs":  `{"books": [{"id": 7, "title": "a"}, {"id": 7, "title": "b"}]}`,
+		"truncated gzip": "\x1f\x8b\x08\x00",
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			newTestRouter(t, nil)
+			path := filepath.Join(t.TempDir(), "books.json")
+			if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			loadCatalog(path)
+
+			if persistLoaded {
+				t.Error("persistLoaded is set after loading a corrupt file")
+			}
+			if ids := bookIDs(books); !slices.Equal(ids, bookIDs(builtinBooks)) {
+				t.Errorf("books = %v, want the builtin books %v", ids, bookIDs(builtinBooks))
+			}
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("corrupt file left in place: %v", err)
+			}
+			aside, _ := filepath.Glob(path + ".corrupt-*")
+			if len(aside) != 1 {
+				t.Fatalf("moved aside files = %v, want one", aside)
+			}
+			if got, _ := os.ReadFile(aside[0]); string(got) != data {
+				t.Errorf("moved aside file holds %q, want %q", got, data)
+			}
+		})
+	}
+}
+
+func TestPersistRoundTrip(t *testing.T) {
+	for _, compress := range []bool{true, false} {
+		t.Run(fmt.Sprintf("gzip=%t", compress), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "books.json.gz")
+			r := newTestRouter(t, func(c *config) {
+				c.PersistFile = path
+				c.PersistGzip = compress
+			})
+			mustCreate(t, r, `{"id": 10, "title": "Saved", "author": "A", "quantity": 3, "tags": ["kept"]}`)
+			if w := do(r, http.MethodGet, "/checkout?id=10&borrower=ann", ""); w.Code != http.StatusOK {
+				t.Fatalf("checkout: status %d, body %s", w.Code, w.Body)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := bytes.HasPrefix(data, gzipMagic); got != compress {
+				t.Fatalf("file gzip-compressed = %t, want %t", got, compress)
+			}
+			wantBooks := append([]book(nil), books...)
+			wantLoans := append([]loan(nil), loans...)
+
+			// Load it back into a fresh catalog.
+			newTestRouter(t, nil)
+			loadCatalog(path)
+			if !persistLoaded {
+				t.Fatal("catalog not loaded")
+			}
+			if !reflect.DeepEqual(books, wantBooks) {
+				t.Errorf("books = %+v, want %+v", books, wantBooks)
+			}
+			if !reflect.DeepEqual(loans, wantLoans) {
+				t.Errorf("loans = %+v, want %+v", loans, wantLoans)
+			}
+			if b, err := getBookById(10); err != nil || b.Quantity != 2 {
+				t.Errorf("book 10 = %+v, %v, want 2 copies left", b, err)
+			}
+			if nextLoanID != 2 {
+				t.Errorf("nextLoanID = %d, want 2", nextLoanID)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The modes of limitRate.
+const (
+	rateLimitOff     = "off"
+	rateLimitWarn    = "warn"
+	rateLimitEnforce = "enforce"
+)
+
+// tokenBucket is the rate-limit state of one client. It holds up to burst
+// tokens and refills continuously; every request takes one.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimitSweepEvery is how often limitRate forgets the buckets that have
+// refilled completely, which behave exactly like new ones.
+const rateLimitSweepEvery = time.Minute
+
+// limitRate limits every client, by IP, to perMinute requests a minute on
+// average with bursts of up to burst requests, as a token bucket. Requests
+// over the limit are rejected with 429 Too Many Requests and a Retry-After
+// header. Every response carries the state of the client's bucket so
+// well-behaved clients can slow down before they are blocked:
+//
+//   - X-RateLimit-Limit: the size of the bucket, burst
+//   - X-RateLimit-Remaining: the requests left in it
+//   - X-RateLimit-Reset: the seconds until it is full again
+//
+// The /health liveness check is exempt. A zero perMinute or the mode "off"
+// disables the limit, and a zero burst means perMinute.
+//
+// In the mode "warn", the limit is observed but not enforced: requests over
+// it are logged and counted in the request metrics, then served as usual,
+// and no rate-limit headers are sent, so clients behave as if there were
+// no limit.
+func limitRate(perMinute, burst int, mode string) gin.HandlerFunc {
+	if perMinute == 0 || mode == rateLimitOff {
+		return func(c *gin.Context) { c.Next() }
+	}
+	if burst == 0 {
+		burst = perMinute
+	}
+	rate := float64(perMinute) / 60 // tokens per second
+	capacity := float64(burst)
+	limit := strconv.Itoa(burst)
+	var mu sync.Mutex
+	buckets := map[string]*tokenBucket{}
+	lastSweep := time.Now()
+
+	return func(c *gin.Context) {
+		if routeOf(c) == "/health" {
+			c.Next()
+			return
+		}
+		now := time.Now()
+
+		mu.Lock()
+		if now.Sub(lastSweep) >= rateLimitSweepEvery {
+			for ip, b := range buckets {
+				if b.tokens+now.Sub(b.last).Seconds()*rate >= capacity {
+					delete(buckets, ip)
+				}
+			}
+			lastSweep = now
+		}
+		b, ok := buckets[c.ClientIP()]
+		if !ok {
+			b = &tokenBucket{tokens: capacity, last: now}
+			buckets[c.ClientIP()] = b
+		}
+		b.tokens = min(capacity, b.tokens+now.Sub(b.last).Seconds()*rate)
+		b.last = now
+		allowed := b.tokens >= 1
+		if allowed {
+			b.tokens--
+		}
+		tokens := b.tokens
+		mu.Unlock()
+
+		if mode == rateLimitWarn {
+			if !allowed {
+				metrics.rateLimitWarnings.Add(1)
+				slog.Warn("rate limit exceeded, not enforced", "client_ip", c.ClientIP(), "method", c.Request.Method, "path", c.Request.URL.Path)
+			}
+			c.Next()
+			return
+		}
+		c.Header("X-RateLimit-Limit", limit)
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(int(tokens)))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil((capacity-tokens)/rate))))
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil((1-tokens)/rate))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, (&apiError{Code: "rate_limited", Message: "Rate limit exceeded, try again later"}).body())
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// wantsPretty reports whether the response should be indented. Output is
+// compact in release mode to save CPU and bytes, and indented otherwise.
+// The `pretty` query parameter overrides the default either way.
+func wantsPretty(c *gin.Context) bool {
+	if v, ok := c.GetQuery("pretty"); ok {
+		if pretty, err := strconv.ParseBool(v); err == nil {
+			return pretty
+		}
+	}
+	return gin.Mode() != gin.ReleaseMode
+}
+
+// renderJSON writes obj as JSON, indented or compact per wantsPretty.
+func renderJSON(c *gin.Context, code int, obj any) {
+	if wantsPretty(c) {
+		c.IndentedJSON(code, obj)
+		return
+	}
+	c.JSON(code, obj)
+}
+
+// marshalJSON encodes obj the same way renderJSON would write it, for
+// responses that are serialized ahead of time.
+func marshalJSON(c *gin.Context, obj any) ([]byte, error) {
+	if wantsPretty(c) {
+		return json.MarshalIndent(obj, "", "    ")
+	}
+	return json.Marshal(obj)
+}
+
+// acceptsProfile reports whether the request's Accept headers include a
+// media range with the given profile, such as
+// `application/json; profile="envelope"`. As in RFC 6906, the profile
+// parameter may list several profiles separated by spaces.
+func acceptsProfile(c *gin.Context, profile string) bool {
+	for _, accept := range c.Request.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			_, params, err := mime.ParseMediaType(mediaRange)
+			if err == nil && slices.Contains(strings.Fields(params["profile"]), profile) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// prefers reports whether the request's Prefer headers (RFC 7240) include
+// the given preference, such as "return=minimal".
+func prefers(c *gin.Context, preference string) bool {
+	for _, header := range c.Request.Header.Values("Prefer") {
+		for _, p := range strings.Split(header, ",") {
+			if p, _, _ = strings.Cut(p, ";"); strings.EqualFold(strings.TrimSpace(p), preference) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeCacheable writes body, the serialized JSON of a cacheable response
+// with the given status, with an ETag derived from it and a Cache-Control header allowing private
+// caches to keep it for CacheMaxAge. A request whose If-None-Match matches
+// the ETag gets 304 Not Modified without a body. Since the representation
+// depends on the Accept profiles, the response varies on Accept.
+//
+// Single books get a strong ETag since their body is exactly reproducible.
+// Lists get a weak one, as the same list may be served from the cache or
+// re-encoded and clients should only rely on the content being equivalent.
+func writeCacheable(c *gin.Context, status int, body []byte, weak bool) {
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	if weak {
+		etag = "W/" + etag
+	}
+	c.Header("ETag", etag)
+	c.Writer.Header().Add("Vary", "Accept")
+	c.Header("Cache-Control", "private, max-age="+strconv.Itoa(int(cfg.CacheMaxAge.Seconds())))
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(status, "application/json; charset=utf-8", body)
+}
+
+// etagMatches reports whether the If-None-Match header value matches etag.
+// As RFC 9110 requires for If-None-Match, the weak comparison is used, so
+// W/"x" and "x" match.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
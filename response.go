@@ -0,0 +1,53 @@
+package main
+
+import "time"
+
+// bookResponse is the representation of a book returned by the API. It is
+// kept separate from the stored book so internal fields are never exposed
+// by accident and derived fields can be added without storing them.
+type bookResponse struct {
+	ID           int64     `json:"id"`
+	Title        string    `json:"title"`
+	Author       string    `json:"author"`
+	Quantity     int       `json:"quantity"`
+	Genre        string    `json:"genre"`
+	ISBN         string    `json:"isbn,omitempty"`
+	Checkoutable bool      `json:"checkoutable"`
+	Tags         []string  `json:"tags,omitempty"`
+	Position     int       `json:"position,omitempty"`
+	Available    bool      `json:"available"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	// DeletedAt is only set on the tombstones of deleted books listed by
+	// getBooks with `since`.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// newBookResponse maps a stored book to its API representation.
+func newBookResponse(b book) bookResponse {
+	return bookResponse{
+		ID:           b.ID,
+		Title:        b.Title,
+		Author:       b.Author,
+		Quantity:     b.Quantity,
+		Genre:        b.Genre,
+		ISBN:         b.ISBN,
+		Checkoutable: b.Checkoutable,
+		Tags:         b.Tags,
+		Position:     b.Position,
+		Available:    b.Quantity > 0,
+		CreatedAt:    b.CreatedAt,
+		UpdatedAt:    b.UpdatedAt,
+		DeletedAt:    b.DeletedAt,
+	}
+}
+
+// newBookResponses maps a list of stored books to their API representation.
+// The result is never nil, so an empty list is encoded as [].
+func newBookResponses(list []book) []bookResponse {
+	out := make([]bookResponse, len(list))
+	for i, b := range list {
+		out[i] = newBookResponse(b)
+	}
+	return out
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// isbnPattern matches an ISBN-10 or ISBN-13, optionally grouped with
+// hyphens or spaces. The check digit is verified by the server only.
+const isbnPattern = `^\s*(?:[0-9][- ]?){9}[0-9Xx]\s*$|^\s*(?:[0-9][- ]?){12}[0-9]\s*$`
+
+// readOnlyFields are the fields of a book set by the server, by their JSON
+// names. Clients may read but not write them.
+var readOnlyFields = map[string]bool{"position": true, "available": true, "created_at": true, "updated_at": true, "deleted_at": true}
+
+// bookSchema returns the JSON Schema of a book as returned by the API. The
+// properties and their types are generated from the JSON tags of
+// bookResponse, so they can't drift from the actual representation, and
+// the constraints enforced on create and update are added from the
+// configuration.
+func bookSchema() gin.H {
+	props := gin.H{}
+	t := reflect.TypeOf(bookResponse{})
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		prop := gin.H{}
+		ft := t.Field(i).Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		switch {
+		case ft == reflect.TypeOf(time.Time{}):
+			prop["type"], prop["format"] = "string", "date-time"
+		case ft.Kind() == reflect.Slice:
+			prop["type"], prop["items"] = "array", gin.H{"type": jsonType(ft.Elem().Kind())}
+		default:
+			prop["type"] = jsonType(ft.Kind())
+		}
+		if readOnlyFields[name] {
+			prop["readOnly"] = true
+		}
+		props[name] = prop
+	}
+
+	constrain := func(name string, constraints gin.H) {
+		for k, v := range constraints {
+			props[name].(gin.H)[k] = v
+		}
+	}
+	constrain("id", gin.H{"minimum": -maxBookID, "maximum": maxBookID})
+	constrain("title", gin.H{"minLength": 1})
+	constrain("quantity", gin.H{"minimum": cfg.MinQuantity, "maximum": cfg.MaxQuantity, "default": cfg.DefaultQuantity})
+	constrain("isbn", gin.H{"pattern": isbnPattern})
+	constrain("checkoutable", gin.H{"default": true})
+	constrain("tags", gin.H{"maxItems": maxTags, "items": gin.H{
+		"type": "string", "minLength": 1, "maxLength": maxTagLength, "pattern": "^[A-Za-z0-9_-]+$",
+	}})
+
+	return gin.H{
+		"$schema":    "https://json-schema.org/draft/2020-12/schema",
+		"title":      "Book",
+		"type":       "object",
+		"properties": props,
+		"required":   []string{"title"},
+	}
+}
+
+// jsonType returns the JSON Schema type of values of a Go kind.
+func jsonType(k reflect.Kind) string {
+	switch k {
+	case reflect.Int, reflect.Int64:
+		return "integer"
+	case reflect.Bool:
+		return "boolean"
+	}
+	return "string"
+}
+
+// getBookSchema handles the HTTP request for the JSON Schema of a book, so
+// front-ends can build and validate forms without hard-coding the rules.
+func getBookSchema(c *gin.Context) {
+	c.Header("Content-Type", "application/schema+json")
+	renderJSON(c, http.StatusOK, bookSchema())
+}
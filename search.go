@@ -0,0 +1,285 @@
+package main
+
+import (
+	"cmp"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxSearchResults caps the number of results a search returns.
+	maxSearchResults = 50
+	// defaultSearchResults is the number of results returned without a limit.
+	defaultSearchResults = 10
+	// defaultMinScore is the relevance below which results are dropped.
+	defaultMinScore = 0.5
+)
+
+// searchResult is a book matched by a search together with its relevance
+// score between 0 and 1, where 1 is an exact or substring match.
+type searchResult struct {
+	bookResponse
+	Score float64 `json:"score"`
+}
+
+// searchEnvelope is the wrapped form of a search response. Its meta echoes
+// the search as interpreted, so clients can tell a search that matched
+// nothing from one that was misread.
+type searchEnvelope struct {
+	Data any        `json:"data"`
+	Meta searchMeta `json:"meta"`
+}
+
+// searchMeta describes how a search was interpreted.
+type searchMeta struct {
+	// Query is the normalized search term of searchBooks.
+	Query   string            `json:"query,omitempty"`
+	Filters map[string]string `json:"filters"`
+	Count   int               `json:"count"`
+}
+
+// searchBooks handles the HTTP request for a fuzzy search over titles and
+// authors. It expects a `q` query parameter and accepts an optional `limit`
+// (capped at 50) and `min_score` between 0 and 1.
+//
+// Matching is forgiving of typos: each book is scored by the edit distance
+// between the query and its title or author, and results are returned best
+// first with the score included.
+//
+// Clients asking for the envelope profile, or all of them when ListEnvelope
+// is configured, get the results wrapped as {"data": [...], "meta": {...}},
+// where meta holds the normalized query, the limit and minimum score
+// applied, and the number of results, even when there are none.
+func searchBooks(c *gin.Context) {
+	q := strings.ToLower(strings.TrimSpace(c.Query("q")))
+	if q == "" {
+		c.JSON(http.StatusBadRequest, invalidRequest("Missing query parameter q").body())
+		return
+	}
+
+	limit := defaultSearchResults
+	if limitStr, ok := c.GetQuery("limit"); ok {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, invalidRequest("Invalid limit").body())
+			return
+		}
+		limit = min(n, maxSearchResults)
+	}
+
+	minScore := defaultMinScore
+	if scoreStr, ok := c.GetQuery("min_score"); ok {
+		f, err := strconv.ParseFloat(scoreStr, 64)
+		if err != nil || f < 0 || f > 1 {
+			c.JSON(http.StatusBadRequest, invalidRequest("Invalid min_score, must be between 0 and 1").body())
+			return
+		}
+		minScore = f
+	}
+
+	results := []searchResult{}
+	booksMu.RLock()
+	for i, b := range books {
+		if i%cancelCheckEvery == 0 && requestCanceled(c) {
+			booksMu.RUnlock()
+			return
+		}
+		if b.isDeleted() {
+			continue
+		}
+		score := max(matchScore(q, b.Title), matchScore(q, b.Author))
+		if score >= minScore {
+			results = append(results, searchResult{bookResponse: newBookResponse(b), Score: math.Round(score*1000) / 1000})
+		}
+	}
+	booksMu.RUnlock()
+	slices.SortStableFunc(results, func(a, b searchResult) int {
+		switch {
+		case a.Score > b.Score:
+			return -1
+		case a.Score < b.Score:
+			return 1
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	if wantsEnvelope(c) {
+		renderJSON(c, http.StatusOK, searchEnvelope{Data: results, Meta: searchMeta{
+			Query: q,
+			Filters: map[string]string{
+				"limit":     strconv.Itoa(limit),
+				"min_score": strconv.FormatFloat(minScore, 'f', -1, 64),
+			},
+			Count: len(results),
+		}})
+		return
+	}
+	renderJSON(c, http.StatusOK, results)
+}
+
+// advancedSearch handles the HTTP request for a precise search by field. It
+// accepts `title`, `author` and `genre` query parameters, each matched as a
+// case-insensitive substring, and returns the books matching all of those
+// given, in catalog order.
+//
+// It responds with 400 Bad Request when none of the parameters is given and
+// an empty array when nothing matches. Like searchBooks, it wraps the
+// results for clients asking for the envelope profile, with the normalized
+// parameters given as filters.
+func advancedSearch(c *gin.Context) {
+	title := strings.ToLower(strings.TrimSpace(c.Query("title")))
+	author := strings.ToLower(strings.TrimSpace(c.Query("author")))
+	genre := strings.ToLower(strings.TrimSpace(c.Query("genre")))
+	if title == "" && author == "" && genre == "" {
+		c.JSON(http.StatusBadRequest, invalidRequest("At least one of the title, author or genre query parameters is required").body())
+		return
+	}
+
+	matched := []book{}
+	booksMu.RLock()
+	for _, b := range books {
+		if b.isDeleted() ||
+			!strings.Contains(strings.ToLower(b.Title), title) ||
+			!strings.Contains(strings.ToLower(b.Author), author) ||
+			!strings.Contains(strings.ToLower(b.Genre), genre) {
+			continue
+		}
+		matched = append(matched, b)
+	}
+	booksMu.RUnlock()
+	if wantsEnvelope(c) {
+		filters := map[string]string{}
+		for name, v := range map[string]string{"title": title, "author": author, "genre": genre} {
+			if v != "" {
+				filters[name] = v
+			}
+		}
+		renderJSON(c, http.StatusOK, searchEnvelope{Data: newBookResponses(matched), Meta: searchMeta{Filters: filters, Count: len(matched)}})
+		return
+	}
+	renderJSON(c, http.StatusOK, newBookResponses(matched))
+}
+
+// getBooksByTitle handles the HTTP request for the live books with a given
+// title, matched exactly but case-insensitively after trimming spaces as on
+// create, e.g. /books/by-title/the%20go%20programming%20language. Titles
+// aren't unique, so it responds with an array in ID order, empty when
+// nothing matches. The title is URL-decoded, and may contain an encoded
+// slash.
+func getBooksByTitle(c *gin.Context) {
+	title := strings.TrimSpace(c.Param("title"))
+
+	matched := []book{}
+	booksMu.RLock()
+	for _, b := range books {
+		if !b.isDeleted() && strings.EqualFold(b.Title, title) {
+			matched = append(matched, b)
+		}
+	}
+	booksMu.RUnlock()
+	slices.SortFunc(matched, func(a, b book) int { return cmp.Compare(a.ID, b.ID) })
+	renderJSON(c, http.StatusOK, newBookResponses(matched))
+}
+
+// maxSuggestions is the number of books suggested for a missing ID.
+const maxSuggestions = 5
+
+// suggestBooks returns up to five live books whose ID is a near miss for
+// id, the kind a typo produces, such as 21 for 12 or 1 for 11: those whose
+// decimal ID is within one edit or one swap of adjacent digits of it,
+// closest in value first. The caller must hold booksMu.
+func suggestBooks(id int64) []bookResponse {
+	want := []rune(strconv.FormatInt(id, 10))
+	var near []book
+	for _, b := range books {
+		got := []rune(strconv.FormatInt(b.ID, 10))
+		if !b.isDeleted() && (levenshtein(want, got) <= 1 || swapsAdjacent(want, got)) {
+			near = append(near, b)
+		}
+	}
+	distance := func(b book) int64 {
+		if b.ID > id {
+			return b.ID - id
+		}
+		return id - b.ID
+	}
+	slices.SortStableFunc(near, func(a, b book) int {
+		if n := cmp.Compare(distance(a), distance(b)); n != 0 {
+			return n
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return newBookResponses(near[:min(len(near), maxSuggestions)])
+}
+
+// swapsAdjacent reports whether b is a with two adjacent runes swapped.
+func swapsAdjacent(a, b []rune) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i+1 < len(a); i++ {
+		if a[i] != b[i] {
+			return a[i] == b[i+1] && a[i+1] == b[i] && slices.Equal(a[i+2:], b[i+2:])
+		}
+	}
+	return false
+}
+
+// matchScore rates how well the lower-cased query q matches field.
+// A substring match scores 1. Otherwise the query is compared with the
+// whole field and with every run of consecutive words of the same length
+// as the query, and the best similarity wins.
+func matchScore(q, field string) float64 {
+	field = strings.ToLower(field)
+	if strings.Contains(field, q) {
+		return 1
+	}
+
+	best := similarity(q, field)
+	words := strings.Fields(field)
+	n := len(strings.Fields(q))
+	for i := 0; i+n <= len(words); i++ {
+		best = max(best, similarity(q, strings.Join(words[i:i+n], " ")))
+	}
+	return best
+}
+
+// similarity turns the Levenshtein distance between a and b into a score
+// between 0 and 1 relative to the length of the longer string.
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+// levenshtein returns the minimum number of single-rune insertions,
+// deletions and substitutions needed to turn a into b.
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
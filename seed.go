@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// loadSeed replaces the built-in seed catalog with the books in the JSON
+// file at path, so each environment can start from its own demo data. The
+// file holds an array of books in the same shape as a create request.
+//
+// Every book is normalized and validated like a create. A file that can't
+// be read or holds an invalid book is logged and the built-in seed is kept,
+// so a bad seed file can't keep the service down.
+func loadSeed(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		slog.Error("failed to read seed file, using the built-in seed", "path", path, "error", err)
+		return
+	}
+	seed, err := decodeSeed(data, time.Now().UTC())
+	if err != nil {
+		slog.Error("invalid seed file, using the built-in seed", "path", path, "error", err)
+		return
+	}
+	useSeed(seed, "file")
+	slog.Info("loaded seed file", "path", path, "books", len(seed))
+}
+
+// maxSeedSize is the largest seed accepted from a URL, in bytes.
+const maxSeedSize = 32 << 20
+
+// loadSeedURL is like loadSeed, with the seed fetched over HTTP(S) from url,
+// e.g. from object storage, so containers can start with a catalog without
+// baking it into the image. A fetch that fails, takes longer than timeout or
+// returns anything but 200 OK is logged and the built-in seed is kept.
+func loadSeedURL(url string, timeout time.Duration) {
+	data, err := fetchSeed(url, timeout)
+	if err != nil {
+		slog.Error("failed to fetch seed, using the built-in seed", "url", url, "error", err)
+		return
+	}
+	seed, err := decodeSeed(data, time.Now().UTC())
+	if err != nil {
+		slog.Error("invalid seed, using the built-in seed", "url", url, "error", err)
+		return
+	}
+	useSeed(seed, "url")
+	slog.Info("loaded seed", "url", url, "books", len(seed))
+}
+
+// fetchSeed returns the body of a GET request for url, of at most
+// maxSeedSize bytes.
+func fetchSeed(url string, timeout time.Duration) ([]byte, error) {
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSeedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSeedSize {
+		return nil, fmt.Errorf("seed larger than %d bytes", maxSeedSize)
+	}
+	return data, nil
+}
+
+// useSeed replaces the catalog with seed, loaded from source.
+func useSeed(seed []book, source string) {
+	booksMu.Lock()
+	defer booksMu.Unlock()
+	books = seed
+	bookIndex = buildIndex(books)
+	seedSource = source
+	seedCount = len(books)
+}
+
+// decodeSeed parses and validates the contents of a seed file, created at
+// now. It returns an error naming the first invalid book.
+func decodeSeed(data []byte, now time.Time) ([]book, error) {
+	var seed []book
+	if err := json.Unmarshal(data, &seed); err != nil {
+		return nil, err
+	}
+	if seed == nil {
+		return nil, errors.New("seed is not a JSON array")
+	}
+	seenIDs := make(map[int64]bool, len(seed))
+	seenISBNs := make(map[string]bool, len(seed))
+	for i := range seed {
+		b := &seed[i]
+		normalizeBook(b)
+		err := checkBookID(b.ID)
+		if err == nil {
+			err = validateFields(*b)
+		}
+		if err == nil && seenIDs[b.ID] {
+			err = errDuplicateID
+		}
+		if err == nil && b.ISBN != "" && seenISBNs[b.ISBN] {
+			err = errDuplicateISBN
+		}
+		if err != nil {
+			return nil, fmt.Errorf("book %d: %w", i, err)
+		}
+		seenIDs[b.ID] = true
+		seenISBNs[b.ISBN] = true
+		b.CreatedAt = now
+		b.UpdatedAt = now
+		b.DeletedAt = nil
+		b.FieldTimes = nil
+	}
+	return seed, nil
+}
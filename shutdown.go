@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// shutdownHook is a cleanup step run by serve once the server has drained.
+type shutdownHook struct {
+	name string
+	run  func(context.Context) error
+}
+
+// shutdownHooks are run in the order they were registered with onShutdown.
+var shutdownHooks []shutdownHook
+
+// onShutdown registers run to be called on shutdown, after the requests in
+// flight have completed or been abandoned, such as to flush buffered
+// telemetry or save state. It must be called before serve.
+func onShutdown(name string, run func(context.Context) error) {
+	shutdownHooks = append(shutdownHooks, shutdownHook{name: name, run: run})
+}
+
+// runShutdownHooks runs every shutdown hook in order. A failing hook is
+// logged and doesn't stop the ones after it.
+func runShutdownHooks(ctx context.Context) {
+	for _, h := range shutdownHooks {
+		if err := h.run(ctx); err != nil {
+			slog.Error("shutdown hook failed", "hook", h.name, "error", err)
+		}
+	}
+}
+
+// serve runs srv until the process receives SIGINT or SIGTERM, then shuts
+// it down gracefully: it stops accepting connections and waits up to
+// drainTimeout for the requests in flight to complete. Requests still
+// running after that are logged as abandoned and their connections are
+// closed, so a stuck request can't hang a deploy forever. Finally the
+// shutdown hooks are run, given drainTimeout again to complete.
+func serve(srv *http.Server, drainTimeout time.Duration) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+	select {
+	case err := <-errc:
+		slog.Error("server failed", "error", err)
+		os.Exit(1)
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down, draining requests", "timeout", drainTimeout)
+	drainCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if err := srv.Shutdown(drainCtx); errors.Is(err, context.DeadlineExceeded) {
+		for _, r := range metrics.activeRequests() {
+			slog.Warn("abandoned request", "method", r.Method, "path", r.Path, "client_ip", r.ClientIP, "running", time.Since(r.Start).Round(time.Millisecond))
+		}
+		srv.Close()
+	}
+
+	hooksCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	runShutdownHooks(hooksCtx)
+	slog.Info("shut down")
+}
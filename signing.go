@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// verifySignature requires mutating requests to be signed with one of the
+// shared secrets, for server-to-server integrations. A signed request
+// carries the Unix time it was sent in X-Timestamp and, in X-Signature, the
+// hex HMAC-SHA256 of the timestamp, the method, the request URI as sent,
+// with its query string, and the body, separated by newlines, optionally
+// prefixed with "sha256=":
+//
+//	X-Signature: hex(HMAC-SHA256(secret, timestamp + "\n" + method + "\n" + requestURI + "\n" + body))
+//
+// The method and URI are signed because many writes, such as DELETE
+// /books/1 or GET /checkout?id=1, carry all their input in the URL; a
+// signature over the body alone would be valid for every one of them.
+//
+// Requests with a missing or wrong signature, or a timestamp more than
+// maxSkew away from the server's clock, are rejected with 401
+// Unauthorized; the latter keeps a captured request from being replayed
+// later. Several secrets may be configured so one can be rotated without
+// downtime. With none, signing is off.
+func verifySignature(secrets []string, maxSkew time.Duration) gin.HandlerFunc {
+	if len(secrets) == 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+	return func(c *gin.Context) {
+		if !isMutating(c) {
+			c.Next()
+			return
+		}
+		ts := c.GetHeader("X-Timestamp")
+		sig, err := hex.DecodeString(strings.TrimPrefix(c.GetHeader("X-Signature"), "sha256="))
+		if ts == "" || err != nil || len(sig) == 0 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, (&apiError{Code: "invalid_signature", Message: "Missing or malformed request signature"}).body())
+			return
+		}
+		sec, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil || time.Since(time.Unix(sec, 0)).Abs() > maxSkew {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, (&apiError{Code: "invalid_timestamp", Message: "Stale or invalid request timestamp"}).body())
+			return
+		}
+
+		var body []byte
+		if c.Request.Body != nil {
+			if body, err = io.ReadAll(c.Request.Body); err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, invalidRequest("Failed to read the request body").body())
+				return
+			}
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		for _, secret := range secrets {
+			if hmac.Equal(sig, signRequest(secret, ts, c.Request, body)) {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, (&apiError{Code: "invalid_signature", Message: "Invalid request signature"}).body())
+	}
+}
+
+// signRequest returns the HMAC-SHA256 of req, sent at the Unix time ts
+// with the given body, as verifySignature expects it.
+func signRequest(secret, ts string, req *http.Request, body []byte) []byte {
+	uri := req.RequestURI
+	if uri == "" {
+		uri = req.URL.RequestURI()
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "\n" + req.Method + "\n" + uri + "\n"))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// doSigned sends a request like do, signed with the secret as of now, or
+// with sig when not empty.
+func doSigned(r http.Handler, secret, method, target, body, sig string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	if sig == "" {
+		sig = hex.EncodeToString(signRequest(secret, ts, req, []byte(body)))
+	}
+	req.Header.Set("X-Timestamp", ts)
+	req.Header.Set("X-Signature", sig)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestSignatureCoversRoute(t *testing.T) {
+	const secret = "s3cret"
+	r := newTestRouter(t, func(c *config) { c.SigningSecrets = []string{secret} })
+
+	if w := do(r, http.MethodDelete, "/books/1", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("unsigned DELETE /books/1: status %d, want 401", w.Code)
+	}
+
+	// A signature for one bodiless write is not valid for another.
+	req := httptest.NewRequest(http.MethodDelete, "/books/1", nil)
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	sig := hex.EncodeToString(signRequest(secret, ts, req, nil))
+	for _, tt := range []struct{ method, target string }{
+		{http.MethodDelete, "/books/2"},
+		{http.MethodGet, "/checkout?id=3&count=5"},
+		{http.MethodPost, "/books/3/clone"},
+		{http.MethodDelete, "/books/1?force=true"},
+	} {
+		if w := doSigned(r, secret, tt.method, tt.target, "", sig); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s with the signature of DELETE /books/1: status %d, want 401", tt.method, tt.target, w.Code)
+		}
+	}
+	if w := doSigned(r, secret, http.MethodDelete, "/books/1", "", sig); w.Code != http.StatusNoContent {
+		t.Errorf("DELETE /books/1 with its own signature: status %d, want 204; body %s", w.Code, w.Body)
+	}
+
+	// Requests signed for themselves go through.
+	if w := doSigned(r, secret, http.MethodGet, "/checkout?id=3", "", ""); w.Code != http.StatusOK {
+		t.Errorf("signed checkout: status %d, body %s", w.Code, w.Body)
+	}
+	if w := doSigned(r, secret, http.MethodPost, "/books", `{"id": 10, "title": "T", "author": "A"}`, ""); w.Code != http.StatusCreated {
+		t.Errorf("signed create: status %d, body %s", w.Code, w.Body)
+	}
+	if w := doSigned(r, "wrong", http.MethodPost, "/books", `{"id": 11, "title": "T", "author": "A"}`, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("create signed with the wrong secret: status %d, want 401", w.Code)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// catalogStats summarizes the inventory.
+type catalogStats struct {
+	Titles        int `json:"titles"`
+	TotalQuantity int `json:"total_quantity"`
+	Available     int `json:"available_titles"`
+	OutOfStock    int `json:"out_of_stock_titles"`
+	DeletedCount  int `json:"deleted_count"`
+}
+
+// getStats handles the HTTP request for inventory statistics.
+// Like getBooks, it only counts live books by default; with
+// `include_deleted=true` deleted books are counted too. The number of
+// deleted books is always reported separately as deleted_count.
+func getStats(c *gin.Context) {
+	includeDeleted := false
+	if v, ok := c.GetQuery("include_deleted"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, invalidRequest("Invalid include_deleted, must be true or false").body())
+			return
+		}
+		includeDeleted = b
+	}
+
+	booksMu.RLock()
+	defer booksMu.RUnlock()
+	var stats catalogStats
+	for _, b := range books {
+		if b.isDeleted() {
+			stats.DeletedCount++
+			if !includeDeleted {
+				continue
+			}
+		}
+		stats.Titles++
+		stats.TotalQuantity += b.Quantity
+		if b.Quantity > 0 {
+			stats.Available++
+		} else {
+			stats.OutOfStock++
+		}
+	}
+	renderJSON(c, http.StatusOK, stats)
+}
+
+// uncategorized is the genre live books without one are grouped under.
+const uncategorized = "uncategorized"
+
+// genreStats is the inventory of one genre, as returned by getStatsByGenre.
+type genreStats struct {
+	Genre         string `json:"genre"`
+	Titles        int    `json:"titles"`
+	TotalQuantity int    `json:"total_quantity"`
+}
+
+// getStatsByGenre handles the HTTP request for the inventory broken down by
+// genre: the number of live titles and their total quantity per genre, most
+// titles first, then by genre. Books without a genre are grouped under
+// "uncategorized".
+func getStatsByGenre(c *gin.Context) {
+	byGenre := map[string]*genreStats{}
+	booksMu.RLock()
+	for _, b := range books {
+		if b.isDeleted() {
+			continue
+		}
+		genre := b.Genre
+		if genre == "" {
+			genre = uncategorized
+		}
+		g, ok := byGenre[genre]
+		if !ok {
+			g = &genreStats{Genre: genre}
+			byGenre[genre] = g
+		}
+		g.Titles++
+		g.TotalQuantity += b.Quantity
+	}
+	booksMu.RUnlock()
+
+	report := make([]genreStats, 0, len(byGenre))
+	for _, g := range byGenre {
+		report = append(report, *g)
+	}
+	slices.SortFunc(report, func(a, b genreStats) int {
+		if n := b.Titles - a.Titles; n != 0 {
+			return n
+		}
+		return cmp.Compare(a.Genre, b.Genre)
+	})
+	renderJSON(c, http.StatusOK, report)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestGetStatsDeleted(t *testing.T) {
+	r := newTestRouter(t, nil)
+	mustCreate(t, r, `{"id": 10, "title": "Out of Print", "author": "A. Author", "quantity": 0}`)
+	mustCreate(t, r, `{"id": 11, "title": "Withdrawn", "author": "A. Author", "quantity": 4}`)
+	for _, id := range []string{"2", "11"} {
+		if w := do(r, http.MethodDelete, "/books/"+id, ""); w.Code != http.StatusNoContent {
+			t.Fatalf("DELETE /books/%s: status %d, body %s", id, w.Code, w.Body)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  catalogStats
+	}{
+		// Live books 1, 3 and 10.
+		{"", catalogStats{Titles: 3, TotalQuantity: 8, Available: 2, OutOfStock: 1, DeletedCount: 2}},
+		{"?include_deleted=false", catalogStats{Titles: 3, TotalQuantity: 8, Available: 2, OutOfStock: 1, DeletedCount: 2}},
+		// Deleted books 2 and 11 too.
+		{"?include_deleted=true", catalogStats{Titles: 5, TotalQuantity: 17, Available: 4, OutOfStock: 1, DeletedCount: 2}},
+	}
+	for _, tt := range tests {
+		w := do(r, http.MethodGet, "/books/stats"+tt.query, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /books/stats%s: status %d, body %s", tt.query, w.Code, w.Body)
+		}
+		var got catalogStats
+		decode(t, w, &got)
+		if got != tt.want {
+			t.Errorf("GET /books/stats%s = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+
+	// The default matches getBooks, which lists the live books only.
+	var listed []book
+	decode(t, do(r, http.MethodGet, "/books", ""), &listed)
+	if len(listed) != 3 {
+		t.Errorf("GET /books listed %d books, want the 3 counted by default", len(listed))
+	}
+
+	if w := do(r, http.MethodGet, "/books/stats?include_deleted=maybe", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("GET /books/stats?include_deleted=maybe: status %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+// booksMu guards books and bookIndex. Handlers hold the read lock while
+// they look at the catalog and the write lock while they change it,
+// including for changes made through a pointer returned by getBookById.
+var booksMu sync.RWMutex
+
+// bookIndex maps a book ID to its position in books, so lookups by ID
+// don't scan the whole catalog. The slice stays the source of truth and
+// keeps the catalog order used by getBooks.
+var bookIndex = buildIndex(books)
+
+// buildIndex returns the ID index of list.
+func buildIndex(list []book) map[int64]int {
+	index := make(map[int64]int, len(list))
+	for i, b := range list {
+		index[b.ID] = i
+	}
+	return index
+}
+
+// maxBookID is the largest book ID accepted, 2^53-1. JSON numbers are
+// read as float64 by JavaScript clients, which can't represent larger
+// integers exactly, so IDs above it could silently change on the client.
+const maxBookID = 1<<53 - 1
+
+// parseBookID parses a book ID from a path or query parameter. It accepts
+// any 64-bit integer, so the result doesn't depend on the platform's int size.
+func parseBookID(s string) (int64, error) {
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// checkBookID checks that id is exactly representable by JavaScript clients.
+func checkBookID(id int64) error {
+	if id > maxBookID || id < -maxBookID {
+		return errIDOutOfRange
+	}
+	return nil
+}
+
+// Errors returned by validateNewBook.
+var (
+	errIDOutOfRange  = &apiError{Code: "id_out_of_range", Message: fmt.Sprintf("ID out of range, must be between %d and %d", -maxBookID, maxBookID)}
+	errEmptyTitle    = &apiError{Code: "empty_title", Message: "Title must not be empty"}
+	errInvalidISBN   = &apiError{Code: "invalid_isbn", Message: "Invalid ISBN"}
+	errTooManyTags   = &apiError{Code: "too_many_tags", Message: fmt.Sprintf("Too many tags, the maximum is %d", maxTags)}
+	errDuplicateID   = &apiError{Code: "duplicate_id", Message: "A book with this ID already exists"}
+	errDuplicateISBN = &apiError{Code: "duplicate_isbn", Message: "A book with this ISBN already exists"}
+)
+
+// validationStatus returns the status of a response rejecting a book for
+// err: 409 Conflict when its ID or ISBN is already taken, and 422
+// Unprocessable Entity for well-formed input that breaks a rule, such as
+// an empty title or an out-of-bounds quantity. Malformed input never gets
+// this far and is answered with 400 Bad Request.
+func validationStatus(err error) int {
+	if errors.Is(err, errDuplicateID) || errors.Is(err, errDuplicateISBN) {
+		return http.StatusConflict
+	}
+	return http.StatusUnprocessableEntity
+}
+
+// bindStatus returns the status of a response rejecting a request body gin
+// failed to bind: 422 Unprocessable Entity when it was well-formed but broke
+// a binding rule, such as a non-positive count, and 400 Bad Request when it
+// couldn't be read at all.
+func bindStatus(err error) int {
+	var invalid validator.ValidationErrors
+	if errors.As(err, &invalid) {
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusBadRequest
+}
+
+// errQuantityOutOfRange is returned by checkQuantity.
+var errQuantityOutOfRange = &apiError{Code: "quantity_out_of_range", Message: "Quantity out of range"}
+
+// checkQuantity checks that q is within the configured quantity bounds.
+// It is the single place the bounds are enforced, so no endpoint can set
+// or change a quantity past them.
+func checkQuantity(q int) error {
+	if q < cfg.MinQuantity || q > cfg.MaxQuantity {
+		return fmt.Errorf("%w, must be between %d and %d", errQuantityOutOfRange, cfg.MinQuantity, cfg.MaxQuantity)
+	}
+	return nil
+}
+
+// validateFields checks the fields of the normalized b that a client may
+// set on create and update: the title must not be empty, the quantity must
+// be within bounds, the ISBN, if any, must be valid and there may be at
+// most 20 valid tags.
+func validateFields(b book) error {
+	if b.Title == "" {
+		return errEmptyTitle
+	}
+	if err := checkQuantity(b.Quantity); err != nil {
+		return err
+	}
+	if b.ISBN != "" && !validISBN(b.ISBN) {
+		return errInvalidISBN
+	}
+	if len(b.Tags) > maxTags {
+		return errTooManyTags
+	}
+	return checkTags(b.Tags)
+}
+
+// validateNewBook checks that the normalized b can be added to the
+// catalog: its ID must be exactly representable by JavaScript clients, its
+// fields must pass validateFields, and neither its ID nor its ISBN may
+// already be taken. The caller must hold booksMu.
+func validateNewBook(b book) error {
+	if err := checkBookID(b.ID); err != nil {
+		return err
+	}
+	if err := validateFields(b); err != nil {
+		return err
+	}
+	if _, exists := bookIndex[b.ID]; exists {
+		return errDuplicateID
+	}
+	if _, exists := getBookByISBN(b.ISBN); exists {
+		return errDuplicateISBN
+	}
+	return nil
+}
+
+// numericField is a numeric field of a book and the size, in bits, of the
+// integer holding it.
+type numericField struct {
+	name string
+	bits int
+}
+
+// numericFields are the numeric fields of a book that loosely-typed
+// clients may send as strings, such as "quantity": "5".
+var numericFields = []numericField{{"id", 64}, {"quantity", strconv.IntSize}}
+
+// numberError is returned by decodeNewBook for a numeric field sent as a
+// string it doesn't accept, or as a number that isn't a finite integer its
+// field can hold.
+type numberError struct {
+	Field string
+	Value string
+	// Reason tells why a value that reads as a number is rejected, such as
+	// "is out of range". It is empty for a value that isn't a number.
+	Reason string
+}
+
+func (e *numberError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("Invalid %s, %s %s", e.Field, e.Value, e.Reason)
+	}
+	if cfg.StrictNumbers {
+		return fmt.Sprintf("Invalid %s, expected a JSON number, not a string", e.Field)
+	}
+	return fmt.Sprintf("Invalid %s, expected a number, got %q", e.Field, e.Value)
+}
+
+// decodeStatus returns the status and error of a response rejecting a
+// book decodeNewBook failed to decode: 422 Unprocessable Entity and err
+// for a numeric field it doesn't accept, and 400 Bad Request and
+// errInvalidJSON for JSON it couldn't read at all.
+func decodeStatus(err error) (int, error) {
+	var numErr *numberError
+	if errors.As(err, &numErr) {
+		return http.StatusUnprocessableEntity, err
+	}
+	return http.StatusBadRequest, errInvalidJSON
+}
+
+// coerceNumbers rewrites the numeric fields of a JSON object sent as
+// strings holding an integer, such as "5", as plain numbers. Other strings
+// are rejected, as are numbers the field can't hold; see checkNumbers.
+// Other values are left for the decoder to judge. With StrictNumbers
+// configured, any numeric field sent as a string is rejected instead.
+func coerceNumbers(data []byte) ([]byte, error) {
+	var obj map[string]json.RawMessage
+	if json.Unmarshal(data, &obj) != nil {
+		return data, nil
+	}
+	changed := false
+	for _, field := range numericFields {
+		// A null is left alone, like an omitted field, rather than read as
+		// an empty string.
+		var sp *string
+		if raw, ok := obj[field.name]; !ok || json.Unmarshal(raw, &sp) != nil || sp == nil {
+			continue
+		}
+		s := strings.TrimSpace(*sp)
+		n, err := strconv.ParseInt(s, 10, field.bits)
+		if err != nil {
+			return nil, &numberError{Field: field.name, Value: s, Reason: numberReason(s, field.bits)}
+		}
+		if cfg.StrictNumbers {
+			return nil, &numberError{Field: field.name, Value: s}
+		}
+		obj[field.name] = json.RawMessage(strconv.FormatInt(n, 10))
+		changed = true
+	}
+	if err := checkNumbers(obj); err != nil {
+		return nil, err
+	}
+	if !changed {
+		return data, nil
+	}
+	return json.Marshal(obj)
+}
+
+// checkNumbers rejects the numeric fields of a JSON object sent as numbers
+// that aren't integers their field can hold, such as 2.5 or 1e400, which
+// would otherwise fail to decode as malformed JSON or, worse, overflow.
+func checkNumbers(obj map[string]json.RawMessage) error {
+	for _, field := range numericFields {
+		var np *json.Number
+		if raw, ok := obj[field.name]; !ok || json.Unmarshal(raw, &np) != nil || np == nil {
+			continue
+		}
+		n := *np
+		if _, err := strconv.ParseInt(n.String(), 10, field.bits); err != nil {
+			return &numberError{Field: field.name, Value: n.String(), Reason: numberReason(n.String(), field.bits)}
+		}
+	}
+	return nil
+}
+
+// numberReason tells why s, which isn't an integer of the given size,
+// is rejected: it isn't finite, such as NaN or Inf, it is out of range, or
+// it isn't an integer. It returns "" when s isn't a number at all.
+func numberReason(s string, bits int) string {
+	f, err := strconv.ParseFloat(s, 64)
+	switch {
+	case errors.Is(err, strconv.ErrRange):
+		return "is out of range"
+	case err != nil:
+		return ""
+	case math.IsNaN(f) || math.IsInf(f, 0):
+		return "is not a finite number"
+	case math.Abs(f) >= math.Ldexp(1, bits-1):
+		return "is out of range"
+	}
+	return "is not an integer"
+}
+
+// catalogFullError is returned by checkCapacity when adding books would
+// take the catalog past MaxBooks.
+type catalogFullError struct {
+	Count int
+	Max   int
+}
+
+func (e *catalogFullError) Error() string {
+	return fmt.Sprintf("Catalog is full, it holds %d of at most %d books", e.Count, e.Max)
+}
+
+// body returns the JSON body of the 507 Insufficient Storage response
+// reporting e, with the current number of books and the cap.
+func (e *catalogFullError) body() gin.H {
+	body := errorBody(e)
+	body["count"], body["max"] = e.Count, e.Max
+	return body
+}
+
+// checkCapacity returns an error when adding n books would take the number
+// of live books past the configured MaxBooks. Deleted books don't count
+// against the cap, but they are stored, so when there is room it drops the
+// oldest of them to keep the catalog, deleted books included, within the
+// cap; see makeRoom. The caller must hold the write lock, and any pointer
+// from getBookById is stale afterwards.
+func checkCapacity(n int) *catalogFullError {
+	if cfg.MaxBooks == 0 {
+		return nil
+	}
+	if full := capacityError(liveBookCount(), n); full != nil {
+		return full
+	}
+	makeRoom(n)
+	return nil
+}
+
+// makeRoom drops the books deleted the longest ago when adding n books
+// would take the number of stored books past the configured MaxBooks, so
+// tombstones don't grow the catalog forever. The caller must hold the write
+// lock.
+func makeRoom(n int) {
+	if cfg.MaxBooks > 0 {
+		purgeTombstones(len(books) + n - cfg.MaxBooks)
+	}
+}
+
+// capacityError returns an error when adding n books to a catalog of count
+// live books would take it past the configured MaxBooks. It lets bulk
+// loads count the books once rather than for every book added.
+func capacityError(count, n int) *catalogFullError {
+	if cfg.MaxBooks > 0 && count+n > cfg.MaxBooks {
+		return &catalogFullError{Count: count, Max: cfg.MaxBooks}
+	}
+	return nil
+}
+
+// liveBookCount returns the number of books that aren't deleted. The caller
+// must hold booksMu.
+func liveBookCount() int {
+	count := 0
+	for _, b := range books {
+		if !b.isDeleted() {
+			count++
+		}
+	}
+	return count
+}
+
+// decodeNewBook decodes the JSON of a book to create and normalizes it. A
+// book without a quantity, or with a null one, gets the configured default
+// quantity, while an explicit 0 is honored. Numeric fields sent as strings
+// are accepted; see coerceNumbers.
+func decodeNewBook(data []byte) (book, error) {
+	data, err := coerceNumbers(data)
+	if err != nil {
+		return book{}, err
+	}
+	var b book
+	// given tells an omitted quantity from an explicit 0, which b alone
+	// can't.
+	var given struct {
+		Quantity *int `json:"quantity"`
+	}
+	if err := json.Unmarshal(data, &b); err != nil {
+		return book{}, err
+	}
+	if json.Unmarshal(data, &given); given.Quantity == nil {
+		b.Quantity = cfg.DefaultQuantity
+	}
+	normalizeBook(&b)
+	return b, nil
+}
+
+// insertBook sets the server-managed fields of b, opens its ledger for the
+// given reason, such as "create", and adds it to the catalog, at the end of
+// the curated order. The caller
+// must hold the write lock and call catalogChanged.
+func insertBook(b *book, now time.Time, reason string) {
+	b.CreatedAt = now
+	b.UpdatedAt = now
+	b.DeletedAt = nil
+	b.FieldTimes = nil
+	for _, field := range trackedFields {
+		touchField(b, field, now)
+	}
+	openLedger(b, reason, now)
+	// Copies are tracked by the server; any sent by the client are dropped
+	// so a new book can't start with lost or checked-out copies.
+	b.Copies = nil
+	syncCopies(b)
+	b.Position = nextPosition()
+	addBook(*b)
+}
+
+// nextPosition returns the position that puts a new book at the end of the
+// curated order, or 0, which also sorts last, when there is no curated
+// order. The caller must hold booksMu.
+func nextPosition() int {
+	last := 0
+	for _, b := range books {
+		if !b.isDeleted() {
+			last = max(last, b.Position)
+		}
+	}
+	if last == 0 {
+		return 0
+	}
+	return last + 1
+}
+
+// nextBookID returns an ID not used by any book, live or deleted. The
+// caller must hold booksMu.
+func nextBookID() int64 {
+	var id int64
+	for _, b := range books {
+		id = max(id, b.ID)
+	}
+	return id + 1
+}
+
+// addBook appends b to the catalog and indexes it. The caller must hold
+// the write lock.
+func addBook(b book) {
+	books = append(books, b)
+	bookIndex[b.ID] = len(books) - 1
+}
+
+// softDeleteBook marks the live book with the given ID as deleted at the
+// given time and reports whether it did. The book stays in the catalog, and
+// keeps its ID, but is hidden from every read. The caller must hold the
+// write lock.
+func softDeleteBook(id int64, at time.Time) bool {
+	i, ok := bookIndex[id]
+	if !ok || books[i].isDeleted() {
+		return false
+	}
+	books[i].DeletedAt = &at
+	books[i].UpdatedAt = at
+	return true
+}
+
+// purgeTombstones removes the n books deleted the longest ago from the
+// catalog, or every deleted book when there are fewer, and rebuilds the ID
+// index. The caller must hold the write lock.
+func purgeTombstones(n int) {
+	if n <= 0 {
+		return
+	}
+	var deleted []book
+	for _, b := range books {
+		if b.isDeleted() {
+			deleted = append(deleted, b)
+		}
+	}
+	if len(deleted) == 0 {
+		return
+	}
+	slices.SortFunc(deleted, func(a, b book) int {
+		return a.DeletedAt.Compare(*b.DeletedAt)
+	})
+	drop := make(map[int64]bool, n)
+	for _, b := range deleted[:min(n, len(deleted))] {
+		drop[b.ID] = true
+	}
+	books = slices.DeleteFunc(books, func(b book) bool { return drop[b.ID] })
+	bookIndex = buildIndex(books)
+}
+
+// reindex handles the admin request to rebuild every structure derived from
+// the `books` slice, the source of truth: the ID index is rebuilt and the
+// getBooks response cache is emptied. It is an escape hatch for recovering
+// from any inconsistency, and reports how many index entries were wrong and
+// how many cached responses were dropped.
+func reindex(c *gin.Context) {
+	booksMu.Lock()
+	defer booksMu.Unlock()
+
+	rebuilt := buildIndex(books)
+	repaired := 0
+	for id, i := range rebuilt {
+		if j, ok := bookIndex[id]; !ok || i != j {
+			repaired++
+		}
+	}
+	for id := range bookIndex {
+		if _, ok := rebuilt[id]; !ok {
+			repaired++
+		}
+	}
+	bookIndex = rebuilt
+	cleared := booksCache.clear()
+
+	slog.Info("rebuilt derived catalog state", "index_entries", len(rebuilt), "index_repaired", repaired, "cache_cleared", cleared)
+	renderJSON(c, http.StatusOK, gin.H{
+		"id_index":   gin.H{"entries": len(rebuilt), "repaired": repaired},
+		"list_cache": gin.H{"cleared": cleared},
+	})
+}
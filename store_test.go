@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+// boundedQuantity configures quantities between 1 and 10.
+func boundedQuantity(c *config) {
+	c.MinQuantity = 1
+	c.MaxQuantity = 10
+}
+
+func TestQuantityBoundsCreate(t *testing.T) {
+	r := newTestRouter(t, boundedQuantity)
+	for _, q := range []int{0, 11, -1} {
+		w := do(r, http.MethodPost, "/books", fmt.Sprintf(`{"id": 10, "title": "T", "author": "A", "quantity": %d}`, q))
+		wantError(t, w, http.StatusUnprocessableEntity, "quantity_out_of_range")
+	}
+	mustCreate(t, r, `{"id": 10, "title": "T", "author": "A", "quantity": 1}`)
+	mustCreate(t, r, `{"id": 11, "title": "T", "author": "A", "quantity": 10}`)
+}
+
+func TestQuantityBoundsUpdate(t *testing.T) {
+	r := newTestRouter(t, boundedQuantity)
+	for _, q := range []int{0, 11} {
+		w := do(r, http.MethodPut, "/books/1", fmt.Sprintf(`{"title": "T", "author": "A", "quantity": %d}`, q))
+		wantError(t, w, http.StatusUnprocessableEntity, "quantity_out_of_range")
+	}
+	for _, q := range []int{1, 10} {
+		w := do(r, http.MethodPut, "/books/1", fmt.Sprintf(`{"title": "T", "author": "A", "quantity": %d}`, q))
+		if w.Code != http.StatusOK {
+			t.Errorf("PUT /books/1 with quantity %d: status %d, body %s", q, w.Code, w.Body)
+		}
+	}
+}
+
+func TestQuantityBoundsRestock(t *testing.T) {
+	r := newTestRouter(t, boundedQuantity)
+	// Book 3 has 6 copies.
+	wantError(t, do(r, http.MethodPost, "/books/3/restock", `{"count": 5}`), http.StatusUnprocessableEntity, "quantity_out_of_range")
+	w := do(r, http.MethodPost, "/books/3/restock", `{"count": 4}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("restocking up to the maximum: status %d, body %s", w.Code, w.Body)
+	}
+	var got book
+	decode(t, w, &got)
+	if got.Quantity != 10 {
+		t.Errorf("quantity after restock = %d, want 10", got.Quantity)
+	}
+	wantError(t, do(r, http.MethodPost, "/books/3/restock", `{"count": 1}`), http.StatusUnprocessableEntity, "quantity_out_of_range")
+}
+
+func TestQuantityBoundsCheckout(t *testing.T) {
+	r := newTestRouter(t, boundedQuantity)
+	// Book 1 has 2 copies, and may not go below 1.
+	wantError(t, do(r, http.MethodGet, "/checkout?id=1&count=2", ""), http.StatusBadRequest, "book_not_available")
+	if w := do(r, http.MethodGet, "/checkout?id=1", ""); w.Code != http.StatusOK {
+		t.Fatalf("checking out down to the minimum: status %d, body %s", w.Code, w.Body)
+	}
+	wantError(t, do(r, http.MethodGet, "/checkout?id=1", ""), http.StatusBadRequest, "book_not_available")
+}
+
+func TestLargeIDs(t *testing.T) {
+	r := newTestRouter(t, nil)
+	const maxID = 1<<53 - 1
+	mustCreate(t, r, fmt.Sprintf(`{"id": %d, "title": "T", "author": "A"}`, maxID))
+	w := do(r, http.MethodGet, fmt.Sprintf("/books/%d", maxID), "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /books/%d: status %d, body %s", maxID, w.Code, w.Body)
+	}
+	var got book
+	decode(t, w, &got)
+	if got.ID != maxID {
+		t.Errorf("GET /books/%d returned ID %d", maxID, got.ID)
+	}
+
+	// IDs above 2^53 can't be represented by JavaScript clients, as numbers
+	// or as strings.
+	for _, id := range []string{"9007199254740992", "9007199254740993", `"9007199254740993"`, "-9007199254740992", "9223372036854775807"} {
+		w := do(r, http.MethodPost, "/books", fmt.Sprintf(`{"id": %s, "title": "T", "author": "A"}`, id))
+		wantError(t, w, http.StatusUnprocessableEntity, "id_out_of_range")
+	}
+	// 2^63 doesn't fit in an int64 at all.
+	wantError(t, do(r, http.MethodPost, "/books", `{"id": 9223372036854775808, "title": "T", "author": "A"}`), http.StatusUnprocessableEntity, "invalid_number")
+
+	// Lookups above 2^53 are exact: they don't round to the book at 2^53-1.
+	for _, target := range []string{"/books/9007199254740992", "/books/9007199254740993", "/checkout?id=9007199254740993"} {
+		wantError(t, do(r, http.MethodGet, target, ""), http.StatusNotFound, "book_not_found")
+	}
+	wantError(t, do(r, http.MethodGet, "/books/9223372036854775808", ""), http.StatusBadRequest, "invalid_id")
+}
+
+func TestCreateDefaultQuantity(t *testing.T) {
+	r := newTestRouter(t, func(c *config) { c.DefaultQuantity = 3 })
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`{"id": 10, "title": "T", "author": "A"}`, 3},
+		{`{"id": 11, "title": "T", "author": "A", "quantity": null}`, 3},
+		{`{"id": 12, "title": "T", "author": "A", "quantity": 0}`, 0},
+		{`{"id": 13, "title": "T", "author": "A", "quantity": "0"}`, 0},
+		{`{"id": 14, "title": "T", "author": "A", "quantity": 5}`, 5},
+	}
+	for _, tt := range tests {
+		w := do(r, http.MethodPost, "/books", tt.body)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("POST /books %s: status %d, body %s", tt.body, w.Code, w.Body)
+		}
+		var got book
+		decode(t, w, &got)
+		if got.Quantity != tt.want {
+			t.Errorf("POST /books %s: quantity %d, want %d", tt.body, got.Quantity, tt.want)
+		}
+	}
+}
+
+func TestNumericBoundaries(t *testing.T) {
+	tests := []struct {
+		quantity string
+		status   int
+		code     string
+	}{
+		{`0`, http.StatusCreated, ""},
+		{`-0`, http.StatusCreated, ""},
+		{`10000`, http.StatusCreated, ""},
+		{`"10000"`, http.StatusCreated, ""},
+		{`1e3`, http.StatusUnprocessableEntity, "invalid_number"},
+		{`10001`, http.StatusUnprocessableEntity, "quantity_out_of_range"},
+		{`-1`, http.StatusUnprocessableEntity, "quantity_out_of_range"},
+		{`9223372036854775807`, http.StatusUnprocessableEntity, "quantity_out_of_range"},
+		{`9223372036854775808`, http.StatusUnprocessableEntity, "invalid_number"},
+		{`-9223372036854775809`, http.StatusUnprocessableEntity, "invalid_number"},
+		{`1e400`, http.StatusUnprocessableEntity, "invalid_number"},
+		{`2.5`, http.StatusUnprocessableEntity, "invalid_number"},
+		{`5.0`, http.StatusUnprocessableEntity, "invalid_number"},
+		{`"NaN"`, http.StatusUnprocessableEntity, "invalid_number"},
+		{`"Infinity"`, http.StatusUnprocessableEntity, "invalid_number"},
+		{`"-Inf"`, http.StatusUnprocessableEntity, "invalid_number"},
+		{`"1e400"`, http.StatusUnprocessableEntity, "invalid_number"},
+		{`"five"`, http.StatusUnprocessableEntity, "invalid_number"},
+		// JSON has no literal for NaN or infinities.
+		{`NaN`, http.StatusBadRequest, "invalid_json"},
+		{`Infinity`, http.StatusBadRequest, "invalid_json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.quantity, func(t *testing.T) {
+			r := newTestRouter(t, nil)
+			w := do(r, http.MethodPost, "/books", `{"id": 10, "title": "T", "author": "A", "quantity": `+tt.quantity+`}`)
+			if tt.code == "" {
+				if w.Code != tt.status {
+					t.Errorf("create: status %d, want %d; body %s", w.Code, tt.status, w.Body)
+				}
+			} else {
+				wantError(t, w, tt.status, tt.code)
+			}
+
+			// An update checks numbers the same way.
+			w = do(r, http.MethodPut, "/books/1", `{"title": "T", "author": "A", "quantity": `+tt.quantity+`}`)
+			if tt.code == "" {
+				if w.Code != http.StatusOK {
+					t.Errorf("update: status %d, want 200; body %s", w.Code, w.Body)
+				}
+			} else {
+				wantError(t, w, tt.status, tt.code)
+			}
+		})
+	}
+}
+
+func TestQuantityBoundsReturn(t *testing.T) {
+	r := newTestRouter(t, boundedQuantity)
+	// Book 3 has 6 copies: check one out, then restock up to the maximum.
+	w := do(r, http.MethodGet, "/checkout?id=3", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("checkout: status %d, body %s", w.Code, w.Body)
+	}
+	loan := w.Header().Get("X-Loan-ID")
+	if w := do(r, http.MethodPost, "/books/3/restock", `{"count": 5}`); w.Code != http.StatusOK {
+		t.Fatalf("restock: status %d, body %s", w.Code, w.Body)
+	}
+	wantError(t, do(r, http.MethodPost, "/loans/"+loan+"/return", ""), http.StatusUnprocessableEntity, "quantity_out_of_range")
+}
+
+func TestQuantityBoundsReturnBatch(t *testing.T) {
+	r := newTestRouter(t, boundedQuantity)
+	if w := do(r, http.MethodGet, "/checkout?id=3", ""); w.Code != http.StatusOK {
+		t.Fatalf("checkout: status %d, body %s", w.Code, w.Body)
+	}
+	if w := do(r, http.MethodPost, "/books/3/restock", `{"count": 5}`); w.Code != http.StatusOK {
+		t.Fatalf("restock: status %d, body %s", w.Code, w.Body)
+	}
+	wantError(t, do(r, http.MethodPost, "/return/batch", `[{"id": 3}]`), http.StatusUnprocessableEntity, "quantity_out_of_range")
+}
+
+func TestCapacityPurgesTombstones(t *testing.T) {
+	r := newTestRouter(t, func(c *config) { c.MaxBooks = 4 })
+	mustCreate(t, r, `{"id": 10, "title": "T", "author": "A"}`)
+	for _, id := range []string{"10", "1"} {
+		if w := do(r, http.MethodDelete, "/books/"+id, ""); w.Code != http.StatusNoContent {
+			t.Fatalf("delete %s: status %d, body %s", id, w.Code, w.Body)
+		}
+	}
+	// Two live books and two tombstones: each new book drops the oldest
+	// tombstone, book 10 first.
+	mustCreate(t, r, `{"id": 11, "title": "T", "author": "A"}`)
+	if _, ok := bookIndex[10]; ok {
+		t.Error("book 10, deleted first, is still stored")
+	}
+	if _, ok := bookIndex[1]; !ok {
+		t.Error("book 1 was dropped before book 10")
+	}
+	if w := do(r, http.MethodPost, "/books/2/clone", ""); w.Code != http.StatusCreated {
+		t.Fatalf("clone: status %d, body %s", w.Code, w.Body)
+	}
+	if ids := bookIDs(books); !slices.Equal(ids, []int64{2, 3, 11, 12}) {
+		t.Errorf("books = %v, want [2 3 11 12]", ids)
+	}
+	if b, err := getBookById(12); err != nil || b.Title != "Concurrency in Go" {
+		t.Errorf("clone = %+v, %v, want a copy of book 2", b, err)
+	}
+	// With no tombstones left, the cap applies to live books.
+	wantError(t, do(r, http.MethodPost, "/books", `{"id": 13, "title": "T", "author": "A"}`), http.StatusInsufficientStorage, "catalog_full")
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxTagLength is the longest tag accepted, in bytes.
+	maxTagLength = 32
+	// maxTags is the number of tags a book may have.
+	maxTags = 20
+)
+
+// errInvalidTag is returned by checkTags.
+var errInvalidTag = &apiError{Code: "invalid_tag", Message: "Invalid tag"}
+
+// normalizeTags lower-cases and trims every tag and returns them sorted
+// without duplicates, or nil when there are none.
+func normalizeTags(tags []string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	out := make([]string, len(tags))
+	for i, tag := range tags {
+		out[i] = strings.ToLower(strings.TrimSpace(tag))
+	}
+	slices.Sort(out)
+	return slices.Compact(out)
+}
+
+// checkTags checks that every normalized tag is valid: made of 1 to 32
+// letters, digits, hyphens and underscores, such as "fiction" or "sci-fi".
+func checkTags(tags []string) error {
+	for _, tag := range tags {
+		if tag == "" || len(tag) > maxTagLength {
+			return fmt.Errorf("%w %q, must be 1 to %d characters", errInvalidTag, tag, maxTagLength)
+		}
+		for _, r := range tag {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+				return fmt.Errorf("%w %q, only letters, digits, - and _ are allowed", errInvalidTag, tag)
+			}
+		}
+	}
+	return nil
+}
+
+// maxTagIDs is the maximum number of books tagBooks updates at once.
+const maxTagIDs = 1000
+
+// tagRequest is the payload of tagBooks.
+type tagRequest struct {
+	IDs    []int64  `json:"ids" binding:"required"`
+	Add    []string `json:"add"`
+	Remove []string `json:"remove"`
+}
+
+// tagBooks handles the HTTP request to add and remove tags across many
+// books at once. It expects a JSON payload such as
+// {"ids": [1, 2], "add": ["fiction"], "remove": ["draft"]}; tags are
+// normalized like on create, and a tag in both lists ends up removed.
+//
+// It responds with 400 Bad Request for malformed JSON, 422 Unprocessable
+// Entity for an invalid tag, too many IDs or when a book would get more than
+// 20 tags, without changing any book, and 200 OK otherwise with the IDs of
+// the books updated and of those not found.
+func tagBooks(c *gin.Context) {
+	var req tagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(bindStatus(err), invalidRequest("Expected a JSON object with an array of integer ids").body())
+		return
+	}
+	if len(req.IDs) > maxTagIDs {
+		c.JSON(http.StatusUnprocessableEntity, invalidRequest("Too many IDs, the maximum is "+strconv.Itoa(maxTagIDs)).body())
+		return
+	}
+	add, remove := normalizeTags(req.Add), normalizeTags(req.Remove)
+	if err := checkTags(slices.Concat(add, remove)); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errorBody(err))
+		return
+	}
+
+	booksMu.Lock()
+	defer booksMu.Unlock()
+	updated, notFound := []int64{}, []int64{}
+	tagged := map[int64][]string{}
+	for _, id := range req.IDs {
+		book, err := getBookById(id)
+		if err != nil {
+			notFound = append(notFound, id)
+			continue
+		}
+		tags := slices.Concat(book.Tags, add)
+		slices.Sort(tags)
+		tags = slices.DeleteFunc(slices.Compact(tags), func(t string) bool { return slices.Contains(remove, t) })
+		if len(tags) > maxTags {
+			c.JSON(http.StatusUnprocessableEntity, errorBody(fmt.Errorf("Book %d: %w", id, errTooManyTags)))
+			return
+		}
+		if len(tags) == 0 {
+			tags = nil
+		}
+		tagged[id] = tags
+	}
+
+	now := time.Now().UTC()
+	for _, id := range req.IDs {
+		tags, ok := tagged[id]
+		if !ok {
+			continue
+		}
+		book, _ := getBookById(id)
+		if !slices.Equal(book.Tags, tags) {
+			book.Tags = tags
+			book.UpdatedAt = now
+			recordAudit(c, "tag", id)
+		}
+		updated = append(updated, id)
+		delete(tagged, id) // report an ID listed twice once
+
+	}
+	if len(updated) > 0 {
+		catalogChanged()
+	}
+	renderJSON(c, http.StatusOK, gin.H{"updated": updated, "not_found": notFound})
+}
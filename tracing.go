@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of the service. Until setupTracing installs a
+// provider it is a no-op, so instrumented code costs next to nothing when
+// tracing is off.
+var tracer = otel.Tracer("example/goApi")
+
+// setupTracing exports traces over OTLP/HTTP when an OTLP endpoint is
+// configured through the standard OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT variables and OTEL_TRACES_EXPORTER
+// isn't "none". The exporter, the service name and the resource attributes
+// are all read from the standard OTEL_* variables. Trace context is
+// propagated in W3C traceparent and baggage headers either way. Buffered
+// spans are flushed on shutdown.
+func setupTracing() {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if os.Getenv("OTEL_TRACES_EXPORTER") == "none" ||
+		os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return
+	}
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		slog.Error("failed to create the OTLP trace exporter, tracing is off", "error", err)
+		return
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(provider)
+	// Spans are exported in batches, so the last ones are flushed on
+	// shutdown rather than lost.
+	onShutdown("tracing", provider.Shutdown)
+	slog.Info("exporting traces over OTLP")
+}
+
+// traceRequests starts a server span for every request, continuing the
+// trace of the caller when the request carries trace context. The span is
+// named after the matched route and stored in the request context, so
+// handlers can add child spans with startSpan.
+func traceRequests() gin.HandlerFunc {
+	propagator := otel.GetTextMapPropagator()
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx := propagator.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		ctx, span := tracer.Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", c.Request.URL.Path),
+				attribute.String("client.address", c.ClientIP()),
+			),
+		)
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, "")
+		}
+	}
+}
+
+// startSpan starts a child span of the request's span around a storage
+// operation such as a lookup. The caller must end it.
+func startSpan(c *gin.Context, name string, attrs ...attribute.KeyValue) trace.Span {
+	_, span := tracer.Start(c.Request.Context(), name, trace.WithAttributes(attrs...))
+	return span
+}